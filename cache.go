@@ -0,0 +1,250 @@
+package mustache
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// typeInfo holds the reflection metadata lookups need about a type, so that
+// it is computed once per type, set of struct tags and match policy rather
+// than on every lookup.
+type typeInfo struct {
+	// methods maps the names of the type's methods to their indexes, and
+	// names the indexes to the names.
+	methods map[string]int
+	names   []string
+	// lookup is the index of the type's Lookup method, or -1 if it has no
+	// Lookup method with the right signature.
+	lookup int
+	// fields maps the names of the exported fields of a struct type,
+	// including the ones promoted from embedded structs, to their index
+	// sequences.
+	fields map[string][]int
+	// tags maps the names given to the fields of a struct type by their tags
+	// to their index sequences.
+	tags map[string][]int
+
+	// match is the policy used for names without an exact match, which are
+	// looked up in matchedMethods and matchedFields by their normalized form.
+	// Names which normalize to the same form are left out of both.
+	match          MatchPolicy
+	matchedMethods map[string]int
+	matchedFields  map[string][]int
+}
+
+// typeKey identifies a type along with the struct tags naming its fields, as
+// set by WithTags, and the policy matching names, as set by WithNameMatching.
+type typeKey struct {
+	typ   reflect.Type
+	tags  string
+	match MatchPolicy
+}
+
+// typeInfos caches the typeInfo of each type, by typeKey. It is safe for
+// concurrent use, so templates can be rendered concurrently.
+var typeInfos sync.Map
+
+// typeInfo returns the metadata for typ, following the settings of the
+// render.
+func (rs *renderState) typeInfo(typ reflect.Type) *typeInfo {
+	key := typeKey{typ, rs.tags, rs.match}
+	if info, ok := typeInfos.Load(key); ok {
+		return info.(*typeInfo)
+	}
+	info := &typeInfo{
+		methods: make(map[string]int, typ.NumMethod()),
+		names:   make([]string, typ.NumMethod()),
+		lookup:  -1,
+		match:   rs.match,
+	}
+	for i := 0; i < typ.NumMethod(); i++ {
+		m := typ.Method(i)
+		info.methods[m.Name] = i
+		info.names[i] = m.Name
+		// Lookup(name string) (interface{}, error)
+		if m.Name == "Lookup" && m.Type.NumIn() == 2 && m.Type.NumOut() == 2 {
+			info.lookup = i
+		}
+	}
+	if typ.Kind() == reflect.Struct {
+		info.fields, info.tags = structFields(typ, strings.Split(rs.tags, ","))
+	}
+
+	if info.match != MatchExact {
+		methods := map[string]int{}
+		ambiguous := map[string]bool{}
+		for name, i := range info.methods {
+			name = normalizeName(name, info.match)
+			if _, ok := methods[name]; ok {
+				ambiguous[name] = true
+			}
+			methods[name] = i
+		}
+		for name := range ambiguous {
+			delete(methods, name)
+		}
+		info.matchedMethods = methods
+
+		fields := fieldCandidates{}
+		for _, names := range []map[string][]int{info.fields, info.tags} {
+			for name, index := range names {
+				fields.add(normalizeName(name, info.match), index)
+			}
+		}
+		info.matchedFields = fields.fields()
+	}
+
+	actual, _ := typeInfos.LoadOrStore(key, info)
+	return actual.(*typeInfo)
+}
+
+// normalizeName returns the form of name compared by the match policy.
+func normalizeName(name string, match MatchPolicy) string {
+	switch match {
+	case MatchCaseInsensitive:
+		return strings.ToLower(name)
+	case MatchNormalized:
+		return strings.Map(func(r rune) rune {
+			if r == '_' || r == '-' {
+				return -1
+			}
+			return unicode.ToLower(r)
+		}, name)
+	}
+	return name
+}
+
+// structFields returns the exported fields of the struct type typ by name,
+// and by the names given by the first of the tag keys they have. The rules of
+// encoding/json apply to each kind of name separately: fields of embedded
+// structs are promoted unless a shallower field has the same name, and two
+// fields with the same name at the same depth hide each other. Fields tagged
+// mustache:"-" are hidden, along with the fields they promote.
+func structFields(typ reflect.Type, keys []string) (fields, tags map[string][]int) {
+	byName, byTag := fieldCandidates{}, fieldCandidates{}
+	visiting := map[reflect.Type]bool{}
+	var walk func(typ reflect.Type, index []int)
+	walk = func(typ reflect.Type, index []int) {
+		visiting[typ] = true
+		defer delete(visiting, typ)
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.Tag.Get("mustache") == "-" {
+				continue
+			}
+			fieldIndex := append(index[:len(index):len(index)], i)
+			if field.Anonymous {
+				t := field.Type
+				if t.Kind() == reflect.Ptr && field.IsExported() {
+					t = t.Elem()
+				}
+				if t.Kind() == reflect.Struct && !visiting[t] {
+					walk(t, fieldIndex)
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+			byName.add(field.Name, fieldIndex)
+			if name := fieldTag(field, keys); name != "" {
+				byTag.add(name, fieldIndex)
+			}
+		}
+	}
+	walk(typ, nil)
+	return byName.fields(), byTag.fields()
+}
+
+// fieldCandidates records, for each name, the shallowest fields with that
+// name: the index sequence of the first one, and how many there are.
+type fieldCandidates map[string]*fieldCandidate
+
+type fieldCandidate struct {
+	index []int
+	count int
+}
+
+func (c fieldCandidates) add(name string, index []int) {
+	switch f, ok := c[name]; {
+	case !ok || len(index) < len(f.index):
+		c[name] = &fieldCandidate{index, 1}
+	case len(index) == len(f.index) && !sameIndex(index, f.index):
+		f.count++
+	}
+}
+
+func sameIndex(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fields returns the index sequences of the names which are not ambiguous.
+func (c fieldCandidates) fields() map[string][]int {
+	fields := make(map[string][]int, len(c))
+	for name, f := range c {
+		if f.count == 1 {
+			fields[name] = f.index
+		}
+	}
+	return fields
+}
+
+// fieldTag returns the name given to field by the first of the tag keys it
+// has, and "" if it has none of them or the name is empty or "-".
+func fieldTag(field reflect.StructField, keys []string) string {
+	for _, key := range keys {
+		tag, ok := field.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		// strip options such as omitempty
+		if name, _, _ := strings.Cut(tag, ","); name != "" && name != "-" {
+			return name
+		}
+		return ""
+	}
+	return ""
+}
+
+// method returns the index of the method found by name, following the match
+// policy.
+func (info *typeInfo) method(name string) (int, bool) {
+	if i, ok := info.methods[name]; ok {
+		return i, true
+	}
+	if info.match == MatchExact {
+		return 0, false
+	}
+	i, ok := info.matchedMethods[normalizeName(name, info.match)]
+	return i, ok
+}
+
+// field returns the field of the struct v found by name, or by tag if no field
+// has that name, following the match policy. It returns false if there is no
+// such field, or if it is promoted through a nil embedded pointer.
+func (info *typeInfo) field(v reflect.Value, name string) (reflect.Value, bool) {
+	index, ok := info.fields[name]
+	if !ok {
+		index, ok = info.tags[name]
+	}
+	if !ok && info.match != MatchExact {
+		index, ok = info.matchedFields[normalizeName(name, info.match)]
+	}
+	if !ok {
+		return reflect.Value{}, false
+	}
+	f, err := v.FieldByIndexErr(index)
+	if err != nil {
+		return reflect.Value{}, false
+	}
+	return f, true
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -21,18 +22,24 @@ var rootCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		err := run(cmd, args)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			message := err.Error()
+			var parseError mustache.ParseError
+			if errors.As(err, &parseError) {
+				message = parseError.ErrorWithSnippet()
+			}
+			fmt.Fprintf(os.Stderr, "Error: %s\n", message)
 			os.Exit(1)
 		}
 	},
 }
 var layoutFile string
 var overrideFile string
+var allowMissingVariables bool
 
 func main() {
 	rootCmd.Flags().StringVar(&layoutFile, "layout", "", "location of layout file")
 	rootCmd.Flags().StringVar(&overrideFile, "override", "", "location of data.yml override yml")
-	rootCmd.Flags().BoolVar(&mustache.AllowMissingVariables, "allow-missing-variables", true, "allow missing variables")
+	rootCmd.Flags().BoolVar(&allowMissingVariables, "allow-missing-variables", true, "allow missing variables")
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
@@ -70,12 +77,24 @@ func run(cmd *cobra.Command, args []string) error {
 			data.(map[interface{}]interface{})[k] = v
 		}
 	}
+	missingVariables := mustache.MissingEmpty
+	if !allowMissingVariables {
+		missingVariables = mustache.MissingError
+	}
+	tmpl, err := mustache.ParseFile(templatePath, mustache.WithMissingVariables(missingVariables))
+	if err != nil {
+		return err
+	}
 	var output string
-	var err error
 	if layoutFile != "" {
-		output, err = mustache.RenderFileInLayout(templatePath, layoutFile, data)
+		var layout *mustache.Template
+		layout, err = mustache.ParseFile(layoutFile, mustache.WithMissingVariables(missingVariables))
+		if err != nil {
+			return err
+		}
+		output, err = tmpl.RenderInLayout(layout, data)
 	} else {
-		output, err = mustache.RenderFile(templatePath, data)
+		output, err = tmpl.Render(data)
 	}
 	if err != nil {
 		return err
@@ -1,7 +1,24 @@
 package mustache
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrPartialNotFound is returned by a PartialProvider when it cannot find the
+// requested partial.
+var ErrPartialNotFound = errors.New("partial not found")
+
+// Errors wrapped by a LimitError, identifying the limit which was exceeded.
+var (
+	ErrPartialDepthLimit = errors.New("partial depth limit exceeded")
+	ErrOutputLimit       = errors.New("output size limit exceeded")
+	ErrIterationLimit    = errors.New("iteration limit exceeded")
+	ErrFunctionCallLimit = errors.New("function call limit exceeded")
 )
 
 // ErrorCode is the list of allowed values for the error's code.
@@ -18,20 +35,72 @@ const (
 	ErrInvalidVariable       ErrorCode = "invalid_variable"
 )
 
+// Error returns the code itself, so that codes can be matched against parse
+// errors with errors.Is.
+func (c ErrorCode) Error() string {
+	return string(c)
+}
+
 // ParseError represents an error during the parsing
 type ParseError struct {
+	// Name is the name of the template, such as its filename, if it has one
+	Name string
 	// Line contains the line of the error
 	Line int
+	// Column contains the column of the error in the line, in characters
+	Column int
+	// Offset contains the byte offset of the error in the template
+	Offset int
 	// Code contains the error code of the error
 	Code ErrorCode
 	// Reason contains the name of the element generating the error
 	Reason string
+
+	// source is the text of the line of the error.
+	source string
 }
 
 func (e ParseError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("%s: line %d: %s", e.Name, e.Line, e.defaultMessage())
+	}
 	return fmt.Sprintf("line %d: %s", e.Line, e.defaultMessage())
 }
 
+// Is reports whether target is the ErrorCode of the error, so that
+// errors.Is(err, ErrSectionNoClosingTag) tells whether err is or holds a
+// ParseError with that code.
+func (e ParseError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == e.Code
+}
+
+// ErrorWithSnippet returns the error message followed by the line of the
+// error, with a caret pointing to the column of the error:
+//
+//	line 2: Section items has no closing tag
+//	  {{#items}}
+//	  ^
+func (e ParseError) ErrorWithSnippet() string {
+	if e.Column < 1 {
+		return e.Error()
+	}
+	// keep the tabs before the column, so the caret lines up
+	var caret strings.Builder
+	for i, r := range []rune(e.source) {
+		if i == e.Column-1 {
+			break
+		}
+		if r == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteRune('^')
+	return e.Error() + "\n" + e.source + "\n" + caret.String()
+}
+
 func (e ParseError) defaultMessage() string {
 	switch e.Code {
 	case ErrUnmatchedOpenTag:
@@ -47,24 +116,52 @@ func (e ParseError) defaultMessage() string {
 	case ErrUnmatchedCloseTag:
 		return "unmatched close tag"
 	case ErrInvalidVariable:
-		return "invalid variable"
+		return fmt.Sprintf("invalid variable: %s", e.Reason)
 	default:
 		return "unknown error"
 	}
 }
 
-func newError(line int, code ErrorCode) ParseError {
-	return ParseError{
-		Line: line,
-		Code: code,
+// ParseErrors lists all the errors found in a template parsed with
+// WithErrorRecovery, in the order of the template. errors.Is and errors.As
+// look through each of them.
+type ParseErrors []ParseError
+
+func (e ParseErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Unwrap returns the errors in the list.
+func (e ParseErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
 	}
+	return errs
 }
 
-func newErrorWithReason(line int, code ErrorCode, reason string) ParseError {
+// newParseError returns the error found at the given byte offset of the
+// template data.
+func newParseError(name, data string, offset int, code ErrorCode, reason string) ParseError {
+	start := strings.LastIndexByte(data[:offset], '\n') + 1
+	end := strings.IndexByte(data[offset:], '\n')
+	if end == -1 {
+		end = len(data)
+	} else {
+		end += offset
+	}
 	return ParseError{
-		Line:   line,
+		Name:   name,
+		Line:   strings.Count(data[:start], "\n") + 1,
+		Column: utf8.RuneCountInString(data[start:offset]) + 1,
+		Offset: offset,
 		Code:   code,
 		Reason: reason,
+		source: strings.TrimSuffix(data[start:end], "\r"),
 	}
 }
 
@@ -73,8 +170,7 @@ type MissingVariableError struct {
 }
 
 func IsMissingVariableError(err error) bool {
-	_, ok := err.(MissingVariableError)
-	return ok
+	return errors.As(err, &MissingVariableError{})
 }
 
 func (e MissingVariableError) Error() string {
@@ -92,8 +188,7 @@ type InvalidVariableError struct {
 }
 
 func IsInvalidVariableError(err error) bool {
-	_, ok := err.(InvalidVariableError)
-	return ok
+	return errors.As(err, &InvalidVariableError{})
 }
 
 func (e InvalidVariableError) Error() string {
@@ -105,3 +200,206 @@ func newInvalidVariableError(name string) InvalidVariableError {
 		Name: name,
 	}
 }
+
+type NilValueError struct {
+	Name string
+}
+
+func IsNilValueError(err error) bool {
+	return errors.As(err, &NilValueError{})
+}
+
+func (e NilValueError) Error() string {
+	return fmt.Sprintf("nil value for variable %q", e.Name)
+}
+
+func newNilValueError(name string) NilValueError {
+	return NilValueError{
+		Name: name,
+	}
+}
+
+// MissingPartialError is returned when a partial cannot be found and missing
+// partials are not allowed. It wraps ErrPartialNotFound.
+type MissingPartialError struct {
+	// Name contains the name of the missing partial
+	Name string
+	// Template contains the name of the template referencing the partial, if
+	// it has one
+	Template string
+	// Line contains the line of the partial tag
+	Line int
+}
+
+func IsMissingPartialError(err error) bool {
+	return errors.As(err, &MissingPartialError{})
+}
+
+func (e MissingPartialError) Error() string {
+	if e.Template != "" {
+		return fmt.Sprintf("%s: line %d: missing partial %q", e.Template, e.Line, e.Name)
+	}
+	return fmt.Sprintf("line %d: missing partial %q", e.Line, e.Name)
+}
+
+func (e MissingPartialError) Unwrap() error {
+	return ErrPartialNotFound
+}
+
+func newMissingPartialError(name, template string, line int) MissingPartialError {
+	return MissingPartialError{
+		Name:     name,
+		Template: template,
+		Line:     line,
+	}
+}
+
+// LimitError is returned when rendering exceeds one of the template's Limits.
+// It wraps the error identifying the limit, such as ErrOutputLimit.
+type LimitError struct {
+	// Err contains the error identifying the exceeded limit
+	Err error
+	// Max contains the value of the limit
+	Max int
+}
+
+func IsLimitError(err error) bool {
+	return errors.As(err, &LimitError{})
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("%s (max %d)", e.Err, e.Max)
+}
+
+func (e LimitError) Unwrap() error {
+	return e.Err
+}
+
+func newLimitError(err error, max int) LimitError {
+	return LimitError{
+		Err: err,
+		Max: max,
+	}
+}
+
+// SandboxError is returned when a template calls a method or function which
+// its Sandbox does not allow.
+type SandboxError struct {
+	// Type contains the receiver type of the method, or nil for functions
+	Type reflect.Type
+	// Name contains the name of the method or function
+	Name string
+}
+
+func IsSandboxError(err error) bool {
+	return errors.As(err, &SandboxError{})
+}
+
+func (e SandboxError) Error() string {
+	if e.Type != nil {
+		return fmt.Sprintf("call to method %s.%s not allowed", e.Type, e.Name)
+	}
+	return fmt.Sprintf("call to function %q not allowed", e.Name)
+}
+
+func newSandboxError(typ reflect.Type, name string) SandboxError {
+	return SandboxError{
+		Type: typ,
+		Name: name,
+	}
+}
+
+// PanicError is returned when a panic is raised while rendering, such as by
+// a method of the data, and recovered. It is wrapped in a RenderError holding
+// the position of the tag which raised it.
+type PanicError struct {
+	// Value contains the value passed to panic
+	Value interface{}
+	// Stack contains the stack trace of the goroutine which panicked, as
+	// formatted by runtime/debug.Stack
+	Stack []byte
+}
+
+func IsPanicError(err error) bool {
+	return errors.As(err, &PanicError{})
+}
+
+func (e PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error, such as a
+// runtime.Error.
+func (e PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+func newPanicError(value interface{}) PanicError {
+	return PanicError{
+		Value: value,
+		Stack: debug.Stack(),
+	}
+}
+
+// RenderError is returned when rendering fails, wrapping the error which
+// stopped it with its position: the tag being rendered, the partial and
+// parent tags through which its template was included, and the items of the
+// sections being iterated. Errors which hold their own position, such as a
+// ParseError or a MissingPartialError, are not wrapped.
+type RenderError struct {
+	// Err contains the error which stopped rendering
+	Err error
+	// Name contains the name of the template being rendered, if it has one
+	Name string
+	// Line and Column contain the position of the tag being rendered, or
+	// zero if rendering failed before the first tag
+	Line   int
+	Column int
+	// Includes contains the partial and parent tags through which the
+	// template was included, outermost first
+	Includes []Include
+	// Path contains the items of the sections being iterated, such as
+	// orders[3].items[1]
+	Path string
+}
+
+// Include locates a partial or parent tag.
+type Include struct {
+	// Name contains the name of the template holding the tag, if it has one
+	Name string
+	// Line and Column contain the position of the tag
+	Line   int
+	Column int
+	// Partial contains the name of the included partial or parent
+	Partial string
+}
+
+func (e RenderError) Error() string {
+	var b strings.Builder
+	if e.Name != "" {
+		b.WriteString(e.Name + ": ")
+	}
+	// errors found before the first tag, such as a cancelled context, have
+	// no position
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "line %d, column %d: ", e.Line, e.Column)
+	}
+	if e.Path != "" {
+		b.WriteString(e.Path + ": ")
+	}
+	b.WriteString(e.Err.Error())
+	for i := len(e.Includes) - 1; i >= 0; i-- {
+		include := e.Includes[i]
+		b.WriteString(", included from ")
+		if include.Name != "" {
+			b.WriteString(include.Name + ": ")
+		}
+		fmt.Fprintf(&b, "line %d, column %d", include.Line, include.Column)
+	}
+	return b.String()
+}
+
+func (e RenderError) Unwrap() error {
+	return e.Err
+}
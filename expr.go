@@ -0,0 +1,343 @@
+package mustache
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// expr is the parsed form of the name in a variable, section or dynamic
+// partial tag. Tag names are paths such as a.b[0].c or a.b[1:3], which may
+// contain function calls such as join(items, ", ") and literals such as
+// "text", 42 or true.
+type expr interface {
+	String() string
+}
+
+// nameExpr is a name looked up in the context chain. The name "." is the top
+// of the context chain. key holds the name as a reflect.Value, ready to index
+// maps with.
+type nameExpr struct {
+	name string
+	key  reflect.Value
+}
+
+// fieldExpr looks up name in the value of x only.
+type fieldExpr struct {
+	x    expr
+	name string
+	key  reflect.Value
+}
+
+// indexExpr indexes the value of x, a map, slice, array or string.
+type indexExpr struct {
+	x     expr
+	index expr
+}
+
+// sliceExpr slices the value of x, a slice, array or string. low and high are
+// nil when omitted.
+type sliceExpr struct {
+	x    expr
+	low  expr
+	high expr
+}
+
+// callExpr calls the function name with the given arguments. The function is
+// looked up in the value of recv if it is not nil, and in the context chain
+// and the registered functions otherwise.
+type callExpr struct {
+	recv expr
+	name string
+	args []expr
+}
+
+// literalExpr is a string, number or boolean literal.
+type literalExpr struct {
+	text  string
+	value reflect.Value
+}
+
+func (e *nameExpr) String() string {
+	return e.name
+}
+
+func (e *fieldExpr) String() string {
+	if x, ok := e.x.(*nameExpr); ok && x.name == "." {
+		return "." + e.name
+	}
+	return e.x.String() + "." + e.name
+}
+
+func (e *indexExpr) String() string {
+	return e.x.String() + "[" + e.index.String() + "]"
+}
+
+func (e *sliceExpr) String() string {
+	var low, high string
+	if e.low != nil {
+		low = e.low.String()
+	}
+	if e.high != nil {
+		high = e.high.String()
+	}
+	return e.x.String() + "[" + low + ":" + high + "]"
+}
+
+func (e *callExpr) String() string {
+	args := make([]string, len(e.args))
+	for i, arg := range e.args {
+		args[i] = arg.String()
+	}
+	call := e.name + "(" + strings.Join(args, ", ") + ")"
+	if e.recv != nil {
+		return e.recv.String() + "." + call
+	}
+	return call
+}
+
+func (e *literalExpr) String() string {
+	return e.text
+}
+
+// exprParser is a recursive descent parser for tag names.
+type exprParser struct {
+	s string
+	p int
+	// brackets counts the brackets being parsed, within which ':' ends
+	// names.
+	brackets int
+}
+
+// parseExpr parses the name of a tag. Names which are not valid expressions
+// but use none of their syntax besides dots, such as "first name", are
+// dotted paths of plain names, as they were before expressions.
+func parseExpr(s string) (expr, error) {
+	ep := &exprParser{s: s}
+	e, err := ep.parse()
+	if err == nil && ep.peek() != 0 {
+		err = fmt.Errorf("unexpected %q", ep.s[ep.p:])
+	}
+	if err != nil {
+		if path, ok := parsePath(s); ok {
+			return path, nil
+		}
+		return nil, err
+	}
+	return e, nil
+}
+
+// parsePath parses s as a dotted path of plain names, whose parts may hold
+// any characters but the ones used by expressions other than spaces. Parts
+// cannot be empty or start with a digit.
+func parsePath(s string) (expr, bool) {
+	if strings.ContainsAny(s, "[](),'\"") {
+		return nil, false
+	}
+	var e expr
+	for _, name := range strings.Split(s, ".") {
+		if name == "" || isDigit(name[0]) {
+			return nil, false
+		}
+		if e == nil {
+			e = &nameExpr{name, reflect.ValueOf(name)}
+		} else {
+			e = &fieldExpr{e, name, reflect.ValueOf(name)}
+		}
+	}
+	return e, true
+}
+
+func (ep *exprParser) skipSpace() {
+	for ep.p < len(ep.s) && isSpace(ep.s[ep.p]) {
+		ep.p++
+	}
+}
+
+// peek returns the next non space character, or 0 at the end of the input.
+func (ep *exprParser) peek() byte {
+	ep.skipSpace()
+	if ep.p == len(ep.s) {
+		return 0
+	}
+	return ep.s[ep.p]
+}
+
+// parse parses a primary expression followed by any number of field
+// accesses, method calls, indexes and slices.
+func (ep *exprParser) parse() (expr, error) {
+	e, err := ep.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch ep.peek() {
+		case '.':
+			ep.p++
+			name := ep.readName()
+			if name == "" {
+				return nil, fmt.Errorf("missing name after '.'")
+			}
+			if e, err = ep.parseName(e, name); err != nil {
+				return nil, err
+			}
+		case '[':
+			ep.p++
+			if e, err = ep.parseIndex(e); err != nil {
+				return nil, err
+			}
+		default:
+			return e, nil
+		}
+	}
+}
+
+// parseIndex parses the index or the slice bounds of x, after the '['.
+func (ep *exprParser) parseIndex(x expr) (expr, error) {
+	ep.brackets++
+	defer func() { ep.brackets-- }()
+	var low, high expr
+	var err error
+	if ep.peek() != ':' {
+		if low, err = ep.parse(); err != nil {
+			return nil, err
+		}
+	}
+	slice := ep.peek() == ':'
+	if slice {
+		ep.p++
+		if ep.peek() != ']' {
+			if high, err = ep.parse(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if ep.peek() != ']' {
+		return nil, fmt.Errorf("missing ']'")
+	}
+	ep.p++
+	if slice {
+		return &sliceExpr{x, low, high}, nil
+	}
+	return &indexExpr{x, low}, nil
+}
+
+func (ep *exprParser) parsePrimary() (expr, error) {
+	switch c := ep.peek(); {
+	case c == 0:
+		return nil, fmt.Errorf("missing name")
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(ep.s[ep.p+1:], c)
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		text := ep.s[ep.p : ep.p+end+2]
+		ep.p += end + 2
+		return &literalExpr{text, reflect.ValueOf(text[1 : len(text)-1])}, nil
+	case c >= '0' && c <= '9' || (c == '-' || c == '+') && ep.p+1 < len(ep.s) && isDigit(ep.s[ep.p+1]):
+		return ep.parseNumber()
+	case c == '.':
+		// the implicit iterator, possibly followed by a name as in .name
+		ep.p++
+		dot := &nameExpr{".", reflect.ValueOf(".")}
+		if name := ep.readName(); name != "" {
+			return ep.parseName(dot, name)
+		}
+		return dot, nil
+	}
+
+	name := ep.readName()
+	if name == "" {
+		return nil, fmt.Errorf("unexpected %q", ep.s[ep.p:])
+	}
+	switch name {
+	case "true", "false":
+		if ep.peek() != '(' {
+			return &literalExpr{name, reflect.ValueOf(name == "true")}, nil
+		}
+	}
+	return ep.parseName(nil, name)
+}
+
+// parseName parses the name found after x, or at the start of the expression
+// if x is nil, along with its arguments if it is a call.
+func (ep *exprParser) parseName(x expr, name string) (expr, error) {
+	if isDigit(name[0]) {
+		return nil, fmt.Errorf("invalid name %q", name)
+	}
+	if ep.peek() != '(' {
+		if x == nil {
+			return &nameExpr{name, reflect.ValueOf(name)}, nil
+		}
+		return &fieldExpr{x, name, reflect.ValueOf(name)}, nil
+	}
+	ep.p++
+	args := []expr{}
+	for ep.peek() != ')' {
+		if len(args) > 0 {
+			if ep.peek() != ',' {
+				return nil, fmt.Errorf("missing ',' or ')'")
+			}
+			ep.p++
+		}
+		arg, err := ep.parse()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	ep.p++
+	return &callExpr{x, name, args}, nil
+}
+
+// readName reads a name, made of any characters but spaces and the ones used
+// by expressions. Within brackets, ':' separates slice bounds.
+func (ep *exprParser) readName() string {
+	start := ep.p
+	for ep.p < len(ep.s) && !isSpace(ep.s[ep.p]) && !strings.ContainsRune(".[](),'\"", rune(ep.s[ep.p])) {
+		if ep.s[ep.p] == ':' && ep.brackets > 0 {
+			break
+		}
+		ep.p++
+	}
+	return ep.s[start:ep.p]
+}
+
+// parseNumber parses an integer or floating point literal, using the syntax of
+// Go literals.
+func (ep *exprParser) parseNumber() (expr, error) {
+	start := ep.p
+	ep.p++
+loop:
+	for ep.p < len(ep.s) {
+		c, prev := ep.s[ep.p], ep.s[ep.p-1]
+		switch {
+		case isDigit(c) || c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case c == '.' && ep.p+1 < len(ep.s) && isDigit(ep.s[ep.p+1]):
+		case (c == '+' || c == '-') && strings.IndexByte("eEpP", prev) >= 0:
+		default:
+			break loop
+		}
+		ep.p++
+	}
+	text := ep.s[start:ep.p]
+	if v, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return &literalExpr{text, reflect.ValueOf(v)}, nil
+	}
+	if v, err := strconv.ParseUint(text, 0, 64); err == nil {
+		return &literalExpr{text, reflect.ValueOf(v)}, nil
+	}
+	if v, err := strconv.ParseFloat(text, 64); err == nil {
+		return &literalExpr{text, reflect.ValueOf(v)}, nil
+	}
+	return nil, fmt.Errorf("invalid number %q", text)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
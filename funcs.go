@@ -0,0 +1,141 @@
+package mustache
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// FuncMap maps names to functions which templates can call, as in
+// {{upper(name)}}. Each function must return a single value, or a value and
+// an error; a non-nil error stops rendering. A function may take a
+// context.Context as its first parameter, in which case it receives the
+// render's context.
+type FuncMap map[string]interface{}
+
+// WithFuncs registers functions which the template and its partials can call.
+// They are looked up after the context chain, so the data can override them.
+// Registered functions are always allowed by the template's Sandbox. Like
+// text/template, WithFuncs panics if a value in funcs is not a function with
+// suitable return values.
+func WithFuncs(funcs FuncMap) Option {
+	values := make(map[string]reflect.Value, len(funcs))
+	for name, fn := range funcs {
+		v := reflect.ValueOf(fn)
+		if v.Kind() != reflect.Func {
+			panic(fmt.Sprintf("mustache: value for %q is not a function", name))
+		}
+		if !goodFunc(v.Type()) {
+			panic(fmt.Sprintf("mustache: function %q must return a value, or a value and an error", name))
+		}
+		values[name] = v
+	}
+	return func(tmpl *Template) {
+		if tmpl.funcs == nil {
+			tmpl.funcs = make(map[string]reflect.Value, len(values))
+		}
+		for name, v := range values {
+			tmpl.funcs[name] = v
+		}
+	}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// goodFunc reports whether the function type typ returns a single value, or a
+// value and an error.
+func goodFunc(typ reflect.Type) bool {
+	switch typ.NumOut() {
+	case 1:
+		return true
+	case 2:
+		return typ.Out(1) == errorType
+	}
+	return false
+}
+
+// convertArgs prepares the arguments of a call to the named function of type
+// typ, converting them to the types of the parameters when they are not
+// assignable. Only lossless conversions are made, such as from the int64 of
+// an integer literal to an int parameter.
+func convertArgs(name string, typ reflect.Type, in []reflect.Value) ([]reflect.Value, error) {
+	offset := 0
+	if takesContext(typ) {
+		offset = 1
+	}
+	out := make([]reflect.Value, len(in))
+	for i, arg := range in {
+		var param reflect.Type
+		if j := i + offset; typ.IsVariadic() && j >= typ.NumIn()-1 {
+			param = typ.In(typ.NumIn() - 1).Elem()
+		} else {
+			param = typ.In(j)
+		}
+		if arg.IsValid() && arg.Kind() == reflect.Interface && !arg.IsNil() {
+			arg = arg.Elem()
+		}
+		v, ok := convertArg(arg, param)
+		if !ok {
+			got := "nil"
+			if arg.IsValid() && arg.Kind() != reflect.Interface {
+				got = arg.Type().String()
+			}
+			return nil, fmt.Errorf("function %q: argument %d: expected %s, got %s", name, i+1, param, got)
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// convertArg converts v to type typ, reporting whether this is possible. v
+// is either nil or holds a concrete value.
+func convertArg(v reflect.Value, typ reflect.Type) (reflect.Value, bool) {
+	if !v.IsValid() || v.Kind() == reflect.Interface {
+		switch typ.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			return reflect.Zero(typ), true
+		}
+		return v, false
+	}
+	if v.Type().AssignableTo(typ) {
+		return v, true
+	}
+
+	zero := reflect.Zero(typ)
+	switch {
+	case isInt(v.Kind()) && isInt(typ.Kind()):
+		if zero.OverflowInt(v.Int()) {
+			return v, false
+		}
+	case isInt(v.Kind()) && isUint(typ.Kind()):
+		if v.Int() < 0 || zero.OverflowUint(uint64(v.Int())) {
+			return v, false
+		}
+	case isUint(v.Kind()) && isUint(typ.Kind()):
+		if zero.OverflowUint(v.Uint()) {
+			return v, false
+		}
+	case isUint(v.Kind()) && isInt(typ.Kind()):
+		if v.Uint() > math.MaxInt64 || zero.OverflowInt(int64(v.Uint())) {
+			return v, false
+		}
+	case (isInt(v.Kind()) || isUint(v.Kind()) || isFloat(v.Kind())) && isFloat(typ.Kind()):
+	case v.Kind() == typ.Kind() && v.Type().ConvertibleTo(typ):
+		// named types with the same underlying type, such as string types
+	default:
+		return v, false
+	}
+	return v.Convert(typ), true
+}
+
+func isInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUint(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
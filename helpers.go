@@ -0,0 +1,327 @@
+package mustache
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// StdFuncs returns a library of common helper functions, to be registered
+// with WithFuncs:
+//
+//	upper(s), lower(s), trim(s)    change the case of s, or trim its spaces
+//	replace(s, old, new)           replace all the occurrences of old in s
+//	truncate(s, n, suffix...)      cut s to n characters, adding suffix if cut
+//	pad(s, n), padLeft(s, n)       pad s with spaces to n characters
+//	number(x, decimals)            format x with thousands separators
+//	date(t, layout)                format a time.Time with a time layout, or
+//	                               the name of one, such as "RFC3339"
+//	default(x, fallback)           x, or fallback if x is empty or missing
+//	join(list, sep)                join the elements of list with sep
+//	json(x)                        encode x as JSON
+//	len(x)                         the length of a string, slice or map
+//	eq, ne, lt, le, gt, ge(a, b)   compare numbers, strings or booleans
+//
+// The comparisons can be used as section conditions, as in
+// {{#gt(count, 10)}}many{{/gt(count, 10)}}. The arguments of default may be
+// missing even when missing variables are errors. Widths and numbers of
+// decimals must be between 0 and 10000, so that templates cannot make the
+// helpers allocate huge strings.
+func StdFuncs() FuncMap {
+	return FuncMap{
+		"upper":    strings.ToUpper,
+		"lower":    strings.ToLower,
+		"trim":     strings.TrimSpace,
+		"replace":  strings.ReplaceAll,
+		"truncate": truncate,
+		"pad":      pad,
+		"padLeft":  padLeft,
+		"number":   formatNumber,
+		"date":     formatDate,
+		"default":  lenientFunc(defaultValue),
+		"join":     join,
+		"json":     toJSON,
+		"len":      length,
+		"eq":       comparison(func(c int) bool { return c == 0 }, true),
+		"ne":       comparison(func(c int) bool { return c != 0 }, true),
+		"lt":       comparison(func(c int) bool { return c < 0 }, false),
+		"le":       comparison(func(c int) bool { return c <= 0 }, false),
+		"gt":       comparison(func(c int) bool { return c > 0 }, false),
+		"ge":       comparison(func(c int) bool { return c >= 0 }, false),
+	}
+}
+
+// maxWidth is the largest width and number of decimals the helpers accept.
+const maxWidth = 10000
+
+// checkWidth returns an error if the width n given to the named helper is
+// negative or larger than maxWidth.
+func checkWidth(name string, n int) error {
+	if n < 0 || n > maxWidth {
+		return fmt.Errorf("%s: width %d out of range [0, %d]", name, n, maxWidth)
+	}
+	return nil
+}
+
+func truncate(s string, n int, suffix ...string) (string, error) {
+	if err := checkWidth("truncate", n); err != nil {
+		return "", err
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s, nil
+	}
+	runes := []rune(s)
+	return string(runes[:n]) + strings.Join(suffix, ""), nil
+}
+
+func pad(s string, n int) (string, error) {
+	if err := checkWidth("pad", n); err != nil {
+		return "", err
+	}
+	if count := utf8.RuneCountInString(s); count < n {
+		return s + strings.Repeat(" ", n-count), nil
+	}
+	return s, nil
+}
+
+func padLeft(s string, n int) (string, error) {
+	if err := checkWidth("padLeft", n); err != nil {
+		return "", err
+	}
+	if count := utf8.RuneCountInString(s); count < n {
+		return strings.Repeat(" ", n-count) + s, nil
+	}
+	return s, nil
+}
+
+// formatNumber formats the number x with the given number of decimals, and
+// commas separating the thousands.
+func formatNumber(x interface{}, decimals int) (string, error) {
+	if err := checkWidth("number", decimals); err != nil {
+		return "", err
+	}
+	v := indirect(reflect.ValueOf(x))
+	var s string
+	switch {
+	case isInt(v.Kind()):
+		s = strconv.FormatInt(v.Int(), 10)
+		if decimals > 0 {
+			s += "." + strings.Repeat("0", decimals)
+		}
+	case isUint(v.Kind()):
+		s = strconv.FormatUint(v.Uint(), 10)
+		if decimals > 0 {
+			s += "." + strings.Repeat("0", decimals)
+		}
+	case isFloat(v.Kind()):
+		s = strconv.FormatFloat(v.Float(), 'f', decimals, 64)
+	default:
+		return "", fmt.Errorf("number: cannot format %T", x)
+	}
+
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i:]
+	}
+	var b strings.Builder
+	for i, c := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return sign + b.String() + fraction, nil
+}
+
+// dateLayouts maps names to the layouts of the time package.
+var dateLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RubyDate":    time.RubyDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"Stamp":       time.Stamp,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// formatDate formats t, a time.Time or *time.Time, with a time layout or the
+// name of one.
+func formatDate(t interface{}, layout string) (string, error) {
+	if named, ok := dateLayouts[layout]; ok {
+		layout = named
+	}
+	switch t := t.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case *time.Time:
+		if t == nil {
+			return "", nil
+		}
+		return t.Format(layout), nil
+	}
+	return "", fmt.Errorf("date: cannot format %T", t)
+}
+
+// lenientFunc is the type of functions such as default, whose arguments are
+// nil when they are missing, whatever the policy for missing variables.
+type lenientFunc func(v, fallback interface{}) interface{}
+
+var lenientFuncType = reflect.TypeOf(lenientFunc(nil))
+
+// defaultValue returns v, or fallback if v is empty or missing.
+func defaultValue(v, fallback interface{}) interface{} {
+	if isEmpty(reflect.ValueOf(v)) {
+		return fallback
+	}
+	return v
+}
+
+func join(list interface{}, sep string) (string, error) {
+	v := indirect(reflect.ValueOf(list))
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: cannot join %T", list)
+	}
+	parts := make([]string, v.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func length(x interface{}) (int, error) {
+	v := indirect(reflect.ValueOf(x))
+	switch v.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(v.String()), nil
+	case reflect.Array, reflect.Chan, reflect.Map, reflect.Slice:
+		return v.Len(), nil
+	}
+	return 0, fmt.Errorf("len: cannot take the length of %T", x)
+}
+
+// comparison returns a function comparing its arguments with compareValues.
+func comparison(test func(int) bool, equality bool) func(a, b interface{}) (bool, error) {
+	return func(a, b interface{}) (bool, error) {
+		return compareValues(a, b, test, equality)
+	}
+}
+
+// compareValues compares a and b, and returns the result of test on the
+// comparison. Numbers of any type can be compared with each other, as well as
+// strings; booleans and other comparable values can only be compared for
+// equality.
+func compareValues(a, b interface{}, test func(int) bool, equality bool) (bool, error) {
+	va, vb := indirect(reflect.ValueOf(a)), indirect(reflect.ValueOf(b))
+	if c, ok := compareNumbers(va, vb); ok {
+		return test(c), nil
+	}
+	if va.Kind() == reflect.String && vb.Kind() == reflect.String {
+		return test(strings.Compare(va.String(), vb.String())), nil
+	}
+	if !equality {
+		return false, fmt.Errorf("cannot compare %T and %T", a, b)
+	}
+	if !va.IsValid() || !vb.IsValid() {
+		if va.IsValid() == vb.IsValid() {
+			return test(0), nil
+		}
+		return test(1), nil
+	}
+	if va.Type() != vb.Type() || !va.Type().Comparable() {
+		return false, fmt.Errorf("cannot compare %T and %T", a, b)
+	}
+	eq, err := equal(va.Interface(), vb.Interface())
+	if err != nil {
+		return false, err
+	}
+	if eq {
+		return test(0), nil
+	}
+	return test(1), nil
+}
+
+// equal compares a and b with ==, returning an error rather than panicking
+// when they hold values which cannot be compared, such as structs with slices
+// in interface fields.
+func equal(a, b interface{}) (eq bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot compare %T and %T: %v", a, b, r)
+		}
+	}()
+	return a == b, nil
+}
+
+// compareNumbers compares a and b if they are both numbers, without losing
+// precision when they are both integers.
+func compareNumbers(a, b reflect.Value) (int, bool) {
+	ka, kb := a.Kind(), b.Kind()
+	switch {
+	case isInt(ka) && isInt(kb):
+		return compareOrdered(a.Int(), b.Int()), true
+	case isUint(ka) && isUint(kb):
+		return compareOrdered(a.Uint(), b.Uint()), true
+	case isInt(ka) && isUint(kb):
+		if a.Int() < 0 {
+			return -1, true
+		}
+		return compareOrdered(uint64(a.Int()), b.Uint()), true
+	case isUint(ka) && isInt(kb):
+		if b.Int() < 0 {
+			return 1, true
+		}
+		return compareOrdered(a.Uint(), uint64(b.Int())), true
+	}
+	fa, okA := toFloat(a)
+	fb, okB := toFloat(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	return compareOrdered(fa, fb), true
+}
+
+func toFloat(v reflect.Value) (float64, bool) {
+	switch k := v.Kind(); {
+	case isInt(k):
+		return float64(v.Int()), true
+	case isUint(k):
+		return float64(v.Uint()), true
+	case isFloat(k):
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func compareOrdered[T int64 | uint64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
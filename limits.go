@@ -0,0 +1,96 @@
+package mustache
+
+import (
+	"bytes"
+	"io"
+)
+
+// Limits bounds the resources a template may use while rendering, so that
+// untrusted templates cannot exhaust memory or stack. A zero value for any of
+// the limits means there is no limit. Rendering stops with a LimitError as
+// soon as a limit is exceeded.
+type Limits struct {
+	// MaxPartialDepth is the maximum nesting of partials and parents.
+	MaxPartialDepth int
+	// MaxOutputBytes is the maximum size of the rendered output. Text which
+	// is rendered to be transformed before being output, such as the text
+	// given to lambdas and overrides of blocks which are re-indented, cannot
+	// exceed what is left of it either.
+	MaxOutputBytes int
+	// MaxIterations is the maximum number of times section contents are
+	// rendered, in total.
+	MaxIterations int
+	// MaxFunctionCalls is the maximum number of calls to lambdas, methods and
+	// functions, in total.
+	MaxFunctionCalls int
+}
+
+// WithLimits sets the resource limits applied to each render of the template.
+func WithLimits(limits Limits) Option {
+	return func(tmpl *Template) {
+		tmpl.limits = limits
+	}
+}
+
+// enter records that rendering enters a partial or parent, and fails when this
+// exceeds the maximum partial depth. The caller decrements rs.depth when
+// leaving it.
+func (rs *renderState) enter() error {
+	rs.depth++
+	if max := rs.limits.MaxPartialDepth; max > 0 && rs.depth > max {
+		return newLimitError(ErrPartialDepthLimit, max)
+	}
+	return nil
+}
+
+// limitWriter writes at most max bytes to w. Once the limit is reached, err is
+// set, as well as the err of parent if there is one, and all writes fail.
+type limitWriter struct {
+	w      io.Writer
+	n      int
+	max    int
+	err    error
+	parent *limitWriter
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if lw.err != nil {
+		return 0, lw.err
+	}
+	if lw.n+len(p) <= lw.max {
+		n, err := lw.w.Write(p)
+		lw.n += n
+		return n, err
+	}
+	n, err := lw.w.Write(p[:lw.max-lw.n])
+	lw.n += n
+	if err == nil {
+		err = lw.fail()
+	}
+	return n, err
+}
+
+// fail sets the error of the writer and of its parents, as if the limit was
+// reached, and returns it.
+func (lw *limitWriter) fail() error {
+	if lw.err == nil {
+		if lw.parent != nil {
+			lw.err = lw.parent.fail()
+		} else {
+			lw.err = newLimitError(ErrOutputLimit, lw.max)
+		}
+	}
+	return lw.err
+}
+
+// buffer returns a buffer for text which is rendered to be transformed before
+// being output, and the writer to render it with. When the output is limited,
+// the writer is limited to what is left of the output limit, so that the
+// buffer cannot grow past it.
+func (rs *renderState) buffer() (*bytes.Buffer, io.Writer) {
+	var buf bytes.Buffer
+	if rs.out == nil {
+		return &buf, &buf
+	}
+	return &buf, &limitWriter{w: &buf, max: rs.out.max - rs.out.n, parent: rs.out}
+}
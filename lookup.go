@@ -1,218 +1,279 @@
 package mustache
 
 import (
+	"context"
 	"fmt"
 	"reflect"
-	"strconv"
-	"strings"
+	"unicode/utf8"
 )
 
-// Evaluate interfaces and pointers looking for a value that can look up the name, via a
-// struct field, method, or map key, and return the result of the lookup.
-func lookup(contextChain []interface{}, left any, name string) (reflect.Value, error) {
-	//fmt.Printf("Lookup %q Left: %v\n", name, left)
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Printf("Panic while looking up %q: %s\n", name, r)
+// lookup evaluates the expression e against the context chain.
+func lookup(rs *renderState, contextChain []interface{}, e expr) (reflect.Value, error) {
+	switch e := e.(type) {
+	case *literalExpr:
+		return e.value, nil
+	case *nameExpr:
+		return lookupName(rs, contextChain, e.name, e.key)
+	case *fieldExpr:
+		v, err := lookup(rs, contextChain, e.x)
+		if err != nil {
+			return v, err
 		}
-	}()
+		return lookupName(rs, []interface{}{v}, e.name, e.key)
+	case *indexExpr:
+		return lookupIndex(rs, contextChain, e)
+	case *sliceExpr:
+		return lookupSlice(rs, contextChain, e)
+	case *callExpr:
+		return lookupCall(rs, contextChain, e)
+	}
+	return reflect.Value{}, newInvalidVariableError(e.String())
+}
 
-	// we go from left to right
-	index := strings.IndexAny(name, ".[(")
-	start := ""
-	if index != -1 {
-		start = string(name[index])
+// lookupIndex evaluates an index expression. Map keys and indexes are
+// converted to the required type when possible. Missing keys and indexes out
+// of range are missing variables.
+func lookupIndex(rs *renderState, contextChain []interface{}, e *indexExpr) (reflect.Value, error) {
+	v, err := lookup(rs, contextChain, e.x)
+	if err != nil {
+		return v, err
 	}
+	v = unwrap(v)
 
-	// dot notation
-	if start == "." && name != "." && strings.Contains(name, ".") {
-		parts := strings.SplitN(name, ".", 2)
+	index, err := lookup(rs, contextChain, e.index)
+	if err != nil {
+		return v, err
+	}
+	index = unwrap(index)
 
-		v, err := lookup(contextChain, left, parts[0])
-		if err != nil {
-			return v, err
+	switch v.Kind() {
+	case reflect.Map:
+		key, ok := convertArg(index, v.Type().Key())
+		if !ok {
+			return v, newInvalidVariableError(e.String())
 		}
-		if startsWithNumber(parts[1]) {
-			return v, newInvalidVariableError(name)
+		v = v.MapIndex(key)
+		if !v.IsValid() {
+			return v, newMissingVariableError(e.String())
 		}
-
-		return lookup(contextChain, v, parts[1])
-	} else if start == "[" && strings.Contains(name, "[") && strings.Contains(name, "]") {
-		// split into the array name and the index, and the right hand side
-		openIndex := strings.Index(name, "[")
-		closeIndex := strings.Index(name, "]")
-
-		var arrayVariable, indexVariable, rest string
-		// the array is already in the left hand side
-		if openIndex > 0 {
-			arrayVariable = name[:openIndex]
+		return v, nil
+	case reflect.Array, reflect.Slice, reflect.String:
+		i, ok := convertArg(index, intType)
+		if !ok {
+			return v, newInvalidVariableError(e.String())
 		}
-
-		indexVariable = name[openIndex+1 : closeIndex]
-		if closeIndex+1 < len(name) {
-			rest = name[closeIndex+1:]
+		ret, ok := indexValue(v, int(i.Int()))
+		if !ok {
+			return v, newMissingVariableError(e.String())
 		}
+		return ret, nil
+	}
+	return v, newInvalidVariableError(e.String())
+}
 
-		if rest != "" && rest[0] == '.' {
-			rest = rest[1:]
-		}
+// lookupSlice evaluates a slice expression. Bounds out of range are clamped,
+// so that items[:3] holds at most the first three items.
+func lookupSlice(rs *renderState, contextChain []interface{}, e *sliceExpr) (reflect.Value, error) {
+	v, err := lookup(rs, contextChain, e.x)
+	if err != nil {
+		return v, err
+	}
+	v = unwrap(v)
+
+	var n int
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice:
+		n = v.Len()
+	case reflect.String:
+		n = utf8.RuneCountInString(v.String())
+	default:
+		return v, newInvalidVariableError(e.String())
+	}
 
-		var v reflect.Value
-		var err error
-		if arrayVariable != "" {
-			// look up the array
-			v, err = lookup(contextChain, left, arrayVariable)
-			if err != nil {
-				return v, err
-			}
-		} else {
-			v = left.(reflect.Value)
+	bounds := [2]int{0, n}
+	for i, bound := range []expr{e.low, e.high} {
+		if bound == nil {
+			continue
 		}
-		v = unwrap(v)
-
-		// look up the index
-		indexValue, err := lookup(contextChain, nil, indexVariable)
+		b, err := lookup(rs, contextChain, bound)
 		if err != nil {
 			return v, err
 		}
-		indexValue = unwrap(indexValue)
-
-		//fmt.Printf("left kind %v \n", v.Kind()) //, kindToString(v.Kind()))
-
-		if v.Kind() == reflect.Map {
-			v = v.MapIndex(indexValue)
-			if !v.IsValid() {
-				return v, newInvalidVariableError(name)
-			}
-		} else if v.Kind() == reflect.Array || v.Kind() == reflect.Slice {
-			if indexValue.Kind() != reflect.Int {
-				if !indexValue.CanConvert(reflect.TypeOf(int(0))) {
-					return v, newInvalidVariableError(name)
-				}
-
-				indexValue = indexValue.Convert(reflect.TypeOf(int(0)))
-			}
-
-			v = v.Index(int(indexValue.Int()))
-		} else {
-			return v, newInvalidVariableError(name)
+		b, ok := convertArg(unwrap(b), intType)
+		if !ok {
+			return v, newInvalidVariableError(e.String())
 		}
+		bounds[i] = clampIndex(int(b.Int()), n)
+	}
+	low, high := bounds[0], bounds[1]
+	if high < low {
+		high = low
+	}
 
-		if rest == "" {
-			return v, nil
+	switch v.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(string([]rune(v.String())[low:high])), nil
+	case reflect.Array:
+		if !v.CanAddr() {
+			// only addressable arrays can be sliced
+			array := reflect.New(v.Type()).Elem()
+			array.Set(v)
+			v = array
 		}
+	}
+	return v.Slice(low, high), nil
+}
 
-		return lookup(contextChain, v, rest)
-	} else if start == "(" && strings.Contains(name, "(") && strings.Contains(name, ")") {
-		openIndex := strings.Index(name, "(")
-		closeIndex := strings.Index(name, ")")
+var intType = reflect.TypeOf(int(0))
 
-		funcVariable := name[:openIndex]
-		argsVariable := name[openIndex+1 : closeIndex]
-		rest := name[closeIndex+1:]
-		if rest != "" && rest[0] == '.' {
-			rest = rest[1:]
+// indexValue returns the element at index i of v, a slice, array or string,
+// counting from the end if i is negative. Strings are indexed by characters,
+// which are returned as strings. It returns false if i is out of range.
+func indexValue(v reflect.Value, i int) (reflect.Value, bool) {
+	if v.Kind() == reflect.String {
+		runes := []rune(v.String())
+		if i < 0 {
+			i += len(runes)
 		}
-
-		// function notation
-		// parts := strings.SplitN(name, "(", 2)
-		args := []string{}
-		if argsVariable != "" {
-			args = strings.Split(argsVariable, ",")
+		if i < 0 || i >= len(runes) {
+			return reflect.Value{}, false
 		}
+		return reflect.ValueOf(string(runes[i])), true
+	}
+	if i < 0 {
+		i += v.Len()
+	}
+	if i < 0 || i >= v.Len() {
+		return reflect.Value{}, false
+	}
+	return v.Index(i), true
+}
 
-		// find the function
-		v, err := lookupFunction(contextChain, left, funcVariable, len(args))
-		if err != nil {
-			return v, err
-		}
-		v = unwrap(v)
+// clampIndex returns the slice bound i of a sequence of length n, counting
+// from the end if i is negative, and clamped between 0 and n.
+func clampIndex(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	switch {
+	case i < 0:
+		return 0
+	case i > n:
+		return n
+	}
+	return i
+}
 
-		if v.Kind() != reflect.Func {
-			return v, newInvalidVariableError(name)
+// lookupCall evaluates a function call. The function is looked up in the value
+// of the receiver if there is one, and otherwise in the context chain and then
+// among the template's registered functions.
+func lookupCall(rs *renderState, contextChain []interface{}, e *callExpr) (reflect.Value, error) {
+	var v reflect.Value
+	var err error
+	if e.recv != nil {
+		var recv reflect.Value
+		recv, err = lookup(rs, contextChain, e.recv)
+		if err != nil {
+			return recv, err
 		}
-
-		// call the function
-		in := make([]reflect.Value, 0, len(args))
-		for _, arg := range args {
-			arg = strings.TrimSpace(arg)
-			val, err := lookup(contextChain, nil, arg)
-			if err != nil {
-				return v, err
+		v, err = lookupFunction(rs, []interface{}{recv}, e.name, len(e.args))
+	} else {
+		v, err = lookupFunction(rs, contextChain, e.name, len(e.args))
+		if fn, ok := rs.funcs[e.name]; ok && err != nil && !IsSandboxError(err) {
+			if !acceptsArgs(fn.Type(), len(e.args)) {
+				return reflect.Value{}, fmt.Errorf("function %q: wrong number of arguments: got %d", e.name, len(e.args))
 			}
-			in = append(in, val)
+			v, err = fn, nil
 		}
+	}
+	if err != nil {
+		return v, err
+	}
+	v = unwrap(v)
 
-		ret := v.Call(in)
-		// If the function returns an error, return the error
-		// The error will be the second return value (by convention)
-		if len(ret) > 1 {
-			if !ret[1].IsNil() {
-				return v, ret[1].Interface().(error)
-			}
-		}
+	if v.Kind() != reflect.Func {
+		return v, newInvalidVariableError(e.String())
+	}
 
-		if rest == "" {
-			return ret[0], nil
+	// call the function
+	lenient := rs.allowMissing || v.Type() == lenientFuncType
+	in := make([]reflect.Value, 0, len(e.args))
+	for _, arg := range e.args {
+		val, err := lookup(rs, contextChain, arg)
+		if IsMissingVariableError(err) && lenient {
+			// missing arguments are nil, e.g. for default(x, "none")
+			val, err = reflect.Value{}, nil
+		}
+		if err != nil {
+			return v, err
 		}
+		in = append(in, val)
+	}
 
-		return lookup(contextChain, ret[0], rest)
-
-		// Otherwise, return the value
-		//return ret[0], nil
-	} else if (strings.HasPrefix(name, "\"") && strings.HasSuffix(name, "\"")) || (strings.HasPrefix(name, "'") && strings.HasSuffix(name, "'")) {
-		// string literal
-		return reflect.ValueOf(name[1 : len(name)-1]), nil
-	} else if val, err := strconv.ParseInt(name, 0, 64); err == nil {
-		// integer literal
-		return reflect.ValueOf(val), nil
-	} else if val, err := strconv.ParseUint(name, 0, 64); err == nil {
-		// unsigned integer literal
-		return reflect.ValueOf(val), nil
-	} else if val, err := strconv.ParseFloat(name, 64); err == nil {
-		// float literal
-		return reflect.ValueOf(val), nil
-	} else if val, err := strconv.ParseBool(name); err == nil {
-		// boolean literal
-		return reflect.ValueOf(val), nil
-	} else if val, err := strconv.ParseComplex(name, 64); err == nil {
-		// complex literal
-		return reflect.ValueOf(val), nil
-	}
-
-	localChain := contextChain
-	if left != nil {
-		localChain = []any{left}
+	in, err = convertArgs(e.name, v.Type(), in)
+	if err != nil {
+		return v, err
 	}
+	if err := rs.checkArgs(v, in); err != nil {
+		return v, err
+	}
+	ret, err := rs.call(v, in)
+	if err != nil {
+		return v, err
+	}
+	// If the function returns an error, return the error
+	// The error will be the second return value (by convention)
+	if len(ret) > 1 {
+		if !ret[1].IsNil() {
+			return v, ret[1].Interface().(error)
+		}
+	}
+	return ret[0], nil
+}
 
+// lookupName evaluates interfaces and pointers looking for a value that can
+// look up the name, via a struct field, method, or map key, and returns the
+// result of the lookup. key is the name as a reflect.Value, used as a map key.
+func lookupName(rs *renderState, contextChain []interface{}, name string, key reflect.Value) (reflect.Value, error) {
 Outer:
-	for _, ctx := range localChain {
-		v := ctx.(reflect.Value)
+	for _, c := range contextChain {
+		v := c.(reflect.Value)
 		for v.IsValid() {
 			typ := v.Type()
-			if n := v.Type().NumMethod(); n > 0 {
-				for i := 0; i < n; i++ {
-					m := typ.Method(i)
-					mtyp := m.Type
-
-					// If the method is called Lookup and has the right signature, use it
-					if m.Name == "Lookup" && mtyp.NumIn() == 2 && mtyp.NumOut() == 2 {
-						// The variable is a struct that implements the Lookup method
-						// Lookup(name string) (interface{}, error)
-						ret := v.Method(i).Call([]reflect.Value{reflect.ValueOf(name)})
-
-						// If the method returns an error, return the error
-						if !ret[1].IsNil() {
-							return v, ret[1].Interface().(error)
-						}
-
-						// Otherwise, return the value
-						return ret[0], nil
+			if typ.NumMethod() > 0 {
+				info := rs.typeInfo(typ)
+				// Methods are tried in order, so a method called name is only
+				// preferred to Lookup if it sorts before it.
+				if i, ok := info.method(name); ok && (info.lookup < 0 || i < info.lookup) && acceptsArgs(v.Method(i).Type(), 0) && goodFunc(v.Method(i).Type()) {
+					if err := rs.checkMethod(typ, info.names[i]); err != nil {
+						return v, err
+					}
+					ret, err := rs.call(v.Method(i), nil)
+					if err != nil {
+						return v, err
+					}
+					return ret[0], nil
+				}
+
+				// If the type has a Lookup method with the right signature, use it
+				if i := info.lookup; i >= 0 {
+					// The variable is a struct that implements the Lookup method
+					// Lookup(name string) (interface{}, error)
+					if err := rs.checkMethod(typ, "Lookup"); err != nil {
+						return v, err
+					}
+					ret, err := rs.call(v.Method(i), []reflect.Value{key})
+					if err != nil {
+						return v, err
 					}
 
-					if m.Name == name && mtyp.NumIn() == 1 {
-						return v.Method(i).Call(nil)[0], nil
+					// If the method returns an error, return the error
+					if !ret[1].IsNil() {
+						return v, ret[1].Interface().(error)
 					}
+
+					// Otherwise, return the value
+					return ret[0], nil
 				}
 			}
 			if name == "." {
@@ -224,46 +285,21 @@ Outer:
 			case reflect.Interface:
 				v = av.Elem()
 			case reflect.Struct:
-				ret := av.FieldByName(name)
-				if ret.IsValid() {
-					return ret, nil
-				}
-				// try to find field by json tag
-				ret = av.FieldByNameFunc(func(fieldName string) bool {
-					field, _ := av.Type().FieldByName(fieldName)
-					// if field doesn't have json tag, skip it
-					// if json tag is "-", skip it
-					jsonTag := field.Tag.Get("json")
-					if jsonTag == "" || jsonTag == "-" {
-						return false
-					}
-					// strip omitempty from json tag
-					jsonTag = strings.Split(jsonTag, ",")[0]
-					return jsonTag == name
-				})
-				if ret.IsValid() {
+				// find the field by name, or by tag
+				if ret, ok := rs.typeInfo(typ).field(av, name); ok {
 					return ret, nil
 				}
 				continue Outer
 			case reflect.Map:
-				ret := av.MapIndex(reflect.ValueOf(name))
+				ret := rs.mapIndex(av, name, key)
 				if ret.IsValid() {
 					return ret, nil
 				}
 				continue Outer
-			case reflect.Slice:
+			case reflect.Slice, reflect.Array:
 				if name == "length" || name == "len" {
 					return reflect.ValueOf(av.Len()), nil
 				}
-
-				index, err := strconv.Atoi(name)
-				if err != nil {
-					return v, err
-				}
-				ret := av.Index(index)
-				if ret.IsValid() {
-					return ret, nil
-				}
 				continue Outer
 			default:
 				continue Outer
@@ -274,6 +310,33 @@ Outer:
 	return reflect.Value{}, newMissingVariableError(name)
 }
 
+// mapIndex returns the value of the map m found by name, following the match
+// policy of the render when key, the name as a reflect.Value, is not in m. The
+// value is invalid if there is no such key, if several keys match the name, or
+// if names cannot be keys of m, as with a map[int]int.
+func (rs *renderState) mapIndex(m reflect.Value, name string, key reflect.Value) reflect.Value {
+	key, ok := convertArg(key, m.Type().Key())
+	if !ok {
+		return reflect.Value{}
+	}
+	if v := m.MapIndex(key); v.IsValid() || rs.match == MatchExact {
+		return v
+	}
+	name = normalizeName(name, rs.match)
+	var found reflect.Value
+	for iter := m.MapRange(); iter.Next(); {
+		k := unwrap(iter.Key())
+		if k.Kind() != reflect.String || normalizeName(k.String(), rs.match) != name {
+			continue
+		}
+		if found.IsValid() {
+			return reflect.Value{}
+		}
+		found = iter.Value()
+	}
+	return found
+}
+
 func unwrap(v reflect.Value) reflect.Value {
 	for v.IsValid() {
 		switch v.Kind() {
@@ -291,26 +354,20 @@ func unwrap(v reflect.Value) reflect.Value {
 
 // lookupFunction looks up a function in the context chain.
 // The function can be a method on a struct, a function in a map, or a function in a parent context.
-// The function must have the signature func(args...) (ret, error) or func(args...) ret.
-func lookupFunction(contextChain []interface{}, left any, s string, numInputs int) (reflect.Value, error) {
-	localChain := contextChain
-	if left != nil {
-		localChain = []any{left}
-	}
-
+// The function must have the signature func(args...) (ret, error) or func(args...) ret,
+// optionally taking a context.Context before args.
+func lookupFunction(rs *renderState, contextChain []interface{}, s string, numInputs int) (reflect.Value, error) {
 Outer:
-	for _, ctx := range localChain {
-		v := ctx.(reflect.Value)
+	for _, c := range contextChain {
+		v := c.(reflect.Value)
 		for v.IsValid() {
 			typ := v.Type()
-			if n := v.Type().NumMethod(); n > 0 {
-				for i := 0; i < n; i++ {
-					m := typ.Method(i)
-					mtyp := m.Type
-
-					// If the method is called Lookup and has the right signature, use it
-					if m.Name == s && mtyp.NumIn() == (numInputs+1) && (mtyp.NumOut() == 1 || mtyp.NumOut() == 2) {
-						return v.Method(i), nil
+			if typ.NumMethod() > 0 {
+				info := rs.typeInfo(typ)
+				if i, ok := info.method(s); ok {
+					m := v.Method(i)
+					if mtyp := m.Type(); acceptsArgs(mtyp, numInputs) && (mtyp.NumOut() == 1 || mtyp.NumOut() == 2) {
+						return m, rs.checkMethod(typ, info.names[i])
 					}
 				}
 			}
@@ -318,8 +375,8 @@ Outer:
 			case reflect.Func:
 				mtyp := av.Type()
 
-				if mtyp.NumIn() == numInputs && (mtyp.NumOut() == 1 || mtyp.NumOut() == 2) {
-					return av, nil
+				if acceptsArgs(mtyp, numInputs) && (mtyp.NumOut() == 1 || mtyp.NumOut() == 2) {
+					return av, rs.checkFunc(s)
 				}
 
 				continue Outer
@@ -329,7 +386,7 @@ Outer:
 			case reflect.Interface:
 				v = av.Elem()
 			case reflect.Map:
-				v = av.MapIndex(reflect.ValueOf(s))
+				v = rs.mapIndex(av, s, reflect.ValueOf(s))
 				continue
 			default:
 				continue Outer
@@ -340,10 +397,37 @@ Outer:
 	return reflect.Value{}, fmt.Errorf("missing function %q", s)
 }
 
-func startsWithNumber(s string) bool {
-	if len(s) > 0 && s[0] >= '0' && s[0] <= '9' {
-		return true
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// takesContext reports whether the first parameter of the function type typ is
+// a context.Context.
+func takesContext(typ reflect.Type) bool {
+	return typ.NumIn() > 0 && typ.In(0) == contextType
+}
+
+// acceptsArgs reports whether a function of type typ can be called with n
+// arguments, besides the context.Context it may take.
+func acceptsArgs(typ reflect.Type, n int) bool {
+	params := typ.NumIn()
+	if takesContext(typ) {
+		params--
+	}
+	if typ.IsVariadic() {
+		return n >= params-1
 	}
+	return n == params
+}
 
-	return false
+// call calls fn with the given arguments, preceded by the render's context if
+// fn takes a context.Context. It fails once the render has made the maximum
+// number of function calls.
+func (rs *renderState) call(fn reflect.Value, in []reflect.Value) ([]reflect.Value, error) {
+	rs.calls++
+	if max := rs.limits.MaxFunctionCalls; max > 0 && rs.calls > max {
+		return nil, newLimitError(ErrFunctionCallLimit, max)
+	}
+	if takesContext(fn.Type()) {
+		in = append([]reflect.Value{reflect.ValueOf(&rs.ctx).Elem()}, in...)
+	}
+	return fn.Call(in), nil
 }
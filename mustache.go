@@ -2,20 +2,29 @@ package mustache
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 var (
 	// AllowMissingVariables defines the behavior for a variable "miss." If it
 	// is true (the default), an empty string is emitted. If it is false, an error
 	// is generated instead.
+	//
+	// Deprecated: Use WithMissingVariables, which sets the behavior per
+	// template. AllowMissingVariables only applies to templates parsed without
+	// that option.
 	AllowMissingVariables = true
 )
 
@@ -28,9 +37,47 @@ type LambdaFunc func(text string, render RenderFunc) (string, error)
 // EscapeFunc is used for escaping non-raw values in templates.
 type EscapeFunc func(text string) string
 
-// FormatterFunc is used for formatting values in templates.
+// FormatterFunc is used for formatting values in templates, including nil
+// values unless the template's NilPolicy is NilError or NilPlaceholder.
 type FormatterFunc func(any) (string, error)
 
+// MissingPolicy defines how references to missing values are handled.
+type MissingPolicy uint
+
+const (
+	// MissingEmpty renders missing values as an empty string.
+	MissingEmpty MissingPolicy = iota
+	// MissingError fails the render with an error describing the missing value.
+	MissingError
+)
+
+// NilPolicy defines how variable tags render nil values.
+type NilPolicy uint
+
+const (
+	// NilEmpty renders nil values as an empty string, as required by the spec.
+	NilEmpty NilPolicy = iota
+	// NilError fails the render with a NilValueError.
+	NilError
+	// NilPlaceholder renders nil values as the template's placeholder text.
+	NilPlaceholder
+)
+
+// MatchPolicy defines how the names in tags are matched against struct fields,
+// methods and map keys.
+type MatchPolicy uint
+
+const (
+	// MatchExact only matches identical names.
+	MatchExact MatchPolicy = iota
+	// MatchCaseInsensitive matches names regardless of case, so that name
+	// matches Name and NAME.
+	MatchCaseInsensitive
+	// MatchNormalized matches names regardless of case, underscores and
+	// hyphens, so that first_name matches FirstName and first-name.
+	MatchNormalized
+)
+
 // CallbackInterface provides a way to lookup values in a custom way.
 type CallbackInterface interface {
 	Lookup(name string) (interface{}, error)
@@ -47,12 +94,14 @@ const (
 	Section
 	InvertedSection
 	Partial
+	Parent
+	Block
 )
 
 // Skip all whitespaces apeared after these types of tags until end of line
 // if the line only contains a tag and whitespaces.
 const (
-	SkipWhitespaceTagTypes = "#^/<>=!"
+	SkipWhitespaceTagTypes = "#^/<>=!$"
 )
 
 func (t TagType) String() string {
@@ -68,6 +117,8 @@ var tagNames = []string{
 	Section:         "Section",
 	InvertedSection: "InvertedSection",
 	Partial:         "Partial",
+	Parent:          "Parent",
+	Block:           "Block",
 }
 
 // Tag represents the different mustache tag types.
@@ -91,21 +142,163 @@ type textElement struct {
 }
 
 type varElement struct {
-	name string
-	raw  bool
+	name   string
+	raw    bool
+	line   int
+	column int
+	expr   expr
 }
 
 type sectionElement struct {
-	name      string
-	inverted  bool
+	name     string
+	inverted bool
+	// startline, column and open locate the opening tag: its line and
+	// column, and its offset in the template source.
 	startline int
+	column    int
+	open      int
 	elems     []interface{}
+	expr      expr
+	// start is the offset of the section content in the template source,
+	// text the unparsed content and otag and ctag the delimiters in effect at
+	// the opening tag. They are used to render lambdas.
+	start int
+	text  string
+	otag  string
+	ctag  string
 }
 
 type partialElement struct {
 	name   string
 	indent string
 	prov   PartialProvider
+	// dynamic is set when name is a variable holding the partial's name,
+	// given by expr.
+	dynamic bool
+	expr    expr
+	// tmplName, line and column locate the tag, for error reporting.
+	tmplName string
+	line     int
+	column   int
+}
+
+// blockElement is an overridable {{$name}} block. indent is the intrinsic
+// indentation of its content, used to re-indent overrides, and tmplName the
+// name of the template it was parsed from, for error reporting.
+type blockElement struct {
+	name     string
+	indent   string
+	elems    []interface{}
+	tmplName string
+}
+
+// parentElement is a {{<name}} tag, which renders the named partial with its
+// blocks replaced by the overrides given between the parent tags.
+type parentElement struct {
+	name     string
+	indent   string
+	prov     PartialProvider
+	blocks   []*blockElement
+	tmplName string
+	line     int
+	column   int
+}
+
+// renderState holds the state of a single render pass.
+type renderState struct {
+	ctx context.Context
+	// blocks is the stack of block overrides introduced by parent tags,
+	// outermost first.
+	blocks [][]*blockElement
+	// name, line and column locate the element being rendered, includes the
+	// partial and parent tags it was reached through and path the items of
+	// the sections being iterated, for error reporting.
+	name     string
+	line     int
+	column   int
+	includes []Include
+	path     []pathItem
+
+	// sandbox, funcs, tags, match and allowMissing are the settings of the
+	// rendered template, which also apply to its partials.
+	sandbox      *Sandbox
+	funcs        map[string]reflect.Value
+	tags         string
+	match        MatchPolicy
+	allowMissing bool
+
+	// limits bounds the resources used by the render, and out, depth,
+	// iterations and calls track their use.
+	limits     Limits
+	out        *limitWriter
+	depth      int
+	iterations int
+	calls      int
+}
+
+// wrap returns err wrapped in a RenderError holding the current position in
+// the template. Errors which already hold their position, or wrap a
+// RenderError, are returned as is.
+func (rs *renderState) wrap(err error) error {
+	switch err.(type) {
+	case ParseError, ParseErrors, MissingPartialError:
+		return err
+	}
+	if errors.As(err, &RenderError{}) {
+		return err
+	}
+	return RenderError{
+		Err:      err,
+		Name:     rs.name,
+		Line:     rs.line,
+		Column:   rs.column,
+		Includes: append([]Include(nil), rs.includes...),
+		Path:     rs.pathString(),
+	}
+}
+
+// pathItem is the index of the item of a section being rendered.
+type pathItem struct {
+	name  string
+	index int
+}
+
+// pathString formats the path of the render, as in orders[3].items[1].
+func (rs *renderState) pathString() string {
+	var b strings.Builder
+	for i, item := range rs.path {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		fmt.Fprintf(&b, "%s[%d]", item.name, item.index)
+	}
+	return b.String()
+}
+
+// include records that rendering enters the named partial or parent through
+// the tag at the current position, and returns the function to call when
+// leaving it.
+func (rs *renderState) include(name string) func() {
+	rs.includes = append(rs.includes, Include{Name: rs.name, Line: rs.line, Column: rs.column, Partial: name})
+	rs.name = name
+	return func() {
+		last := rs.includes[len(rs.includes)-1]
+		rs.includes = rs.includes[:len(rs.includes)-1]
+		rs.name, rs.line, rs.column = last.Name, last.Line, last.Column
+	}
+}
+
+// override returns the block overriding the named block, if any. Overrides
+// from outer parents take precedence over inner ones.
+func (rs *renderState) override(name string) *blockElement {
+	for _, blocks := range rs.blocks {
+		for _, block := range blocks {
+			if block.name == name {
+				return block
+			}
+		}
+	}
+	return nil
 }
 
 // Template represents a compilde mustache template
@@ -120,6 +313,48 @@ type Template struct {
 	partial   PartialProvider
 	escape    EscapeFunc
 	formatter FormatterFunc
+
+	nilPolicy      NilPolicy
+	nilPlaceholder string
+
+	// cache holds the compiled partials used when rendering.
+	cache *partialCache
+
+	name            string
+	missingPartials MissingPolicy
+
+	limits  Limits
+	sandbox *Sandbox
+	funcs   map[string]reflect.Value
+	// panics is set by WithPanics, in which case panics raised while
+	// rendering are not recovered.
+	panics bool
+	// tags holds the comma separated keys of the struct tags naming fields,
+	// in order of precedence, and match is the policy matching names.
+	tags  string
+	match MatchPolicy
+
+	// missingVariables is only used when missingVariablesSet, otherwise the
+	// AllowMissingVariables global applies.
+	missingVariables    MissingPolicy
+	missingVariablesSet bool
+
+	// standaloneLine is set while reading a line holding several standalone
+	// tags, which is only allowed for lines holding parent or block tags.
+	// inheritance holds the names of the parents and blocks being parsed,
+	// innermost last, to tell their closing tags.
+	standaloneLine bool
+	inheritance    []string
+
+	// recovery is set by WithErrorRecovery, in which case errs collects the
+	// errors found so far. open holds the names of the sections being
+	// parsed, innermost last, and closing the name and offset of a closing
+	// tag which ends sections up to the one it names.
+	recovery     bool
+	errs         []ParseError
+	open         []string
+	closing      string
+	closingStart int
 }
 
 // Tags returns the mustache tags for the given template
@@ -136,6 +371,29 @@ func (tmpl *Template) Formatter(fn FormatterFunc) {
 	tmpl.formatter = fn
 }
 
+// InvalidatePartials drops the named partials from the template's cache of
+// compiled partials, or all of them when no names are given, so that they are
+// retrieved from the PartialProvider and parsed again on the next render.
+// Partials are otherwise retrieved and parsed only once per template.
+func (tmpl *Template) InvalidatePartials(names ...string) {
+	tmpl.cache.invalidate(names...)
+}
+
+// Name returns the name of the template: the name of its file when it was
+// parsed from one, or its name in a Set.
+func (tmpl *Template) Name() string {
+	return tmpl.name
+}
+
+// allowMissingVariables reports whether missing variables render as an empty
+// string rather than fail.
+func (tmpl *Template) allowMissingVariables() bool {
+	if tmpl.missingVariablesSet {
+		return tmpl.missingVariables == MissingEmpty
+	}
+	return AllowMissingVariables
+}
+
 func extractTags(elems []interface{}) []Tag {
 	tags := make([]Tag, 0, len(elems))
 	for _, elem := range elems {
@@ -146,6 +404,10 @@ func extractTags(elems []interface{}) []Tag {
 			tags = append(tags, elem)
 		case *partialElement:
 			tags = append(tags, elem)
+		case *parentElement:
+			tags = append(tags, elem)
+		case *blockElement:
+			tags = append(tags, elem)
 		}
 	}
 	return tags
@@ -190,6 +452,34 @@ func (e *partialElement) Tags() []Tag {
 	return nil
 }
 
+func (e *parentElement) Type() TagType {
+	return Parent
+}
+
+func (e *parentElement) Name() string {
+	return e.name
+}
+
+func (e *parentElement) Tags() []Tag {
+	tags := make([]Tag, 0, len(e.blocks))
+	for _, block := range e.blocks {
+		tags = append(tags, block)
+	}
+	return tags
+}
+
+func (e *blockElement) Type() TagType {
+	return Block
+}
+
+func (e *blockElement) Name() string {
+	return e.name
+}
+
+func (e *blockElement) Tags() []Tag {
+	return extractTags(e.elems)
+}
+
 func (tmpl *Template) readString(s string) (string, error) {
 	newlines := 0
 	for i := tmpl.p; ; i++ {
@@ -249,7 +539,7 @@ func (tmpl *Template) readText() (*textReadingResult, error) {
 		}
 	}
 
-	mayStandalone := (i == 0 || tmpl.data[i-1] == '\n')
+	mayStandalone := (i == 0 || tmpl.data[i-1] == '\n' || tmpl.standaloneLine && i == pPrev)
 
 	if mayStandalone {
 		return &textReadingResult{
@@ -269,11 +559,18 @@ func (tmpl *Template) readText() (*textReadingResult, error) {
 type tagReadingResult struct {
 	tag        string
 	standalone bool
+	// start and end are the offsets of the tag, including its delimiters, and
+	// line and column the position of its start.
+	start  int
+	end    int
+	line   int
+	column int
 }
 
 func (tmpl *Template) readTag(mayStandalone bool) (*tagReadingResult, error) {
 	var text string
 	var err error
+	start := tmpl.p - len(tmpl.otag)
 	if tmpl.p < len(tmpl.data) && tmpl.data[tmpl.p] == '{' {
 		text, err = tmpl.readString("}" + tmpl.ctag)
 	} else {
@@ -281,16 +578,20 @@ func (tmpl *Template) readTag(mayStandalone bool) (*tagReadingResult, error) {
 	}
 
 	if err == io.EOF {
-		//put the remaining text in a block
-		return nil, newError(tmpl.curline, ErrUnmatchedOpenTag)
+		// there is nothing left to parse
+		tmpl.p = len(tmpl.data)
+		return nil, tmpl.parseError(start, ErrUnmatchedOpenTag, "")
 	}
 
 	text = text[:len(text)-len(tmpl.ctag)]
+	end := tmpl.p
+	line := tmpl.curline - strings.Count(tmpl.data[start:end], "\n")
+	column := utf8.RuneCountInString(tmpl.data[strings.LastIndexByte(tmpl.data[:start], '\n')+1:start]) + 1
 
 	//trim the close tag off the text
 	tag := strings.TrimSpace(text)
 	if len(tag) == 0 {
-		return nil, newError(tmpl.curline, ErrEmptyTag)
+		return nil, tmpl.parseError(start, ErrEmptyTag, "")
 	}
 
 	eow := tmpl.p
@@ -317,27 +618,277 @@ func (tmpl *Template) readTag(mayStandalone bool) (*tagReadingResult, error) {
 				standalone = true
 				tmpl.p = eow + 2
 				tmpl.curline++
+			} else if tmpl.standaloneRest(tag, eow) {
+				// more standalone tags follow on this line; the last of them
+				// consumes the line ending.
+				standalone = true
+				tmpl.standaloneLine = true
+				return &tagReadingResult{
+					tag:        tag,
+					standalone: standalone,
+					start:      start,
+					end:        end,
+					line:       line,
+					column:     column,
+				}, nil
 			} else {
 				standalone = false
 			}
 		}
 	}
+	tmpl.standaloneLine = false
 
 	return &tagReadingResult{
 		tag:        tag,
 		standalone: standalone,
+		start:      start,
+		end:        end,
+		line:       line,
+		column:     column,
 	}, nil
 }
 
-func (tmpl *Template) parsePartial(name, indent string) (*partialElement, error) {
+// standaloneRest reports whether the rest of the line starting at i, after
+// tag, consists only of whitespace and tags which may stand alone, at least
+// one of them or tag being a parent or block tag. All of those tags are then
+// treated as standalone, as the inheritance extension of the spec requires.
+// Lines of several other tags are not standalone, as in the rest of the
+// spec.
+func (tmpl *Template) standaloneRest(tag string, i int) bool {
+	data := tmpl.data
+	inheritance := tmpl.isInheritanceTag(tag)
+	for {
+		for i < len(data) && (data[i] == ' ' || data[i] == '\t') {
+			i++
+		}
+		if i == len(data) || data[i] == '\n' || strings.HasPrefix(data[i:], "\r\n") {
+			return inheritance
+		}
+		if !strings.HasPrefix(data[i:], tmpl.otag) {
+			return false
+		}
+		i += len(tmpl.otag)
+		n := strings.Index(data[i:], tmpl.ctag)
+		if n == -1 {
+			return false
+		}
+		tag := strings.TrimSpace(data[i : i+n])
+		// delimiter changes and multiline tags end the lookahead
+		if tag == "" || tag[0] == '=' || strings.Contains(tag, "\n") || !strings.Contains(SkipWhitespaceTagTypes, tag[0:1]) {
+			return false
+		}
+		inheritance = inheritance || tmpl.isInheritanceTag(tag)
+		i += n + len(tmpl.ctag)
+	}
+}
+
+// isInheritanceTag reports whether tag, without its delimiters, opens a
+// parent or block, or closes one being parsed.
+func (tmpl *Template) isInheritanceTag(tag string) bool {
+	switch tag[0] {
+	case '<', '$':
+		return true
+	case '/':
+		name := strings.TrimSpace(tag[1:])
+		for _, open := range tmpl.inheritance {
+			if open == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (tmpl *Template) parsePartial(name, indent string, tag *tagReadingResult) (*partialElement, error) {
+	// {{>*name}} takes the name of the partial from the context
+	dynamic := strings.HasPrefix(name, "*")
+	var e expr
+	if dynamic {
+		name = strings.TrimSpace(name[1:])
+		var err error
+		if e, err = tmpl.parseTagExpr(name, tag); err != nil {
+			return nil, err
+		}
+	}
 	return &partialElement{
-		name:   name,
-		indent: indent,
-		prov:   tmpl.partial,
+		name:     name,
+		indent:   indent,
+		prov:     tmpl.partial,
+		dynamic:  dynamic,
+		expr:     e,
+		tmplName: tmpl.name,
+		line:     tag.line,
+		column:   tag.column,
 	}, nil
 }
 
+// parseVar parses a variable tag: a name, possibly preceded by & or enclosed
+// in braces for raw output. A tag opened with a brace but not closed with one
+// yields no element.
+func (tmpl *Template) parseVar(tag string, tagResult *tagReadingResult) (*varElement, error) {
+	switch tag[0] {
+	case '{':
+		if tag[len(tag)-1] != '}' {
+			return nil, nil
+		}
+		return tmpl.newVar(strings.TrimSpace(tag[1:len(tag)-1]), true, tagResult)
+	case '&':
+		return tmpl.newVar(strings.TrimSpace(tag[1:]), true, tagResult)
+	}
+	return tmpl.newVar(tag, tmpl.forceRaw, tagResult)
+}
+
+// newVar creates the element for a variable tag.
+func (tmpl *Template) newVar(name string, raw bool, tag *tagReadingResult) (*varElement, error) {
+	e, err := tmpl.parseTagExpr(name, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &varElement{name, raw, tag.line, tag.column, e}, nil
+}
+
+// parseTagExpr parses the name of a variable, section or dynamic partial tag.
+func (tmpl *Template) parseTagExpr(name string, tag *tagReadingResult) (expr, error) {
+	e, err := parseExpr(name)
+	if err != nil {
+		return nil, tmpl.parseError(tag.start, ErrInvalidVariable, name)
+	}
+	return e, nil
+}
+
+// parseError returns the error found at the given offset of the template.
+func (tmpl *Template) parseError(offset int, code ErrorCode, reason string) ParseError {
+	return newParseError(tmpl.name, tmpl.data, offset, code, reason)
+}
+
+// report records err and returns nil if the template is parsed with error
+// recovery and err is a ParseError, so that parsing goes on. Otherwise it
+// returns err.
+func (tmpl *Template) report(err error) error {
+	var parseError ParseError
+	if !tmpl.recovery || !errors.As(err, &parseError) {
+		return err
+	}
+	tmpl.errs = append(tmpl.errs, parseError)
+	return nil
+}
+
+// closedBy reports whether a closing tag found while parsing a nested section
+// also closes section, because it closes section or one of its ancestors.
+func (tmpl *Template) closedBy(section *sectionElement) bool {
+	if tmpl.closing == "" {
+		return false
+	}
+	section.text = tmpl.data[section.start:tmpl.closingStart]
+	if tmpl.closing == section.name {
+		tmpl.closing = ""
+	}
+	return true
+}
+
+// isOpen reports whether a section called name is being parsed.
+func (tmpl *Template) isOpen(name string) bool {
+	for _, open := range tmpl.open {
+		if open == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseParent parses the contents of a {{<name}} tag. Only the blocks between
+// the opening and closing tags are kept; any other content is ignored.
+func (tmpl *Template) parseParent(name, indent string, tag *tagReadingResult) (*parentElement, error) {
+	tmpl.inheritance = append(tmpl.inheritance, name)
+	defer func() { tmpl.inheritance = tmpl.inheritance[:len(tmpl.inheritance)-1] }()
+
+	se := tmpl.newSection(name, false, tag, tmpl.p)
+	if err := tmpl.parseSection(se); err != nil {
+		return nil, err
+	}
+
+	parent := &parentElement{
+		name:     name,
+		indent:   indent,
+		prov:     tmpl.partial,
+		tmplName: tmpl.name,
+		line:     tag.line,
+		column:   tag.column,
+	}
+	for _, elem := range se.elems {
+		if block, ok := elem.(*blockElement); ok {
+			parent.blocks = append(parent.blocks, block)
+		}
+	}
+	return parent, nil
+}
+
+// parseBlock parses the contents of a {{$name}} tag. When the opening tag is
+// standalone, the indentation of the first line of content is recorded, or
+// the padding of the tag if its content starts on the same line.
+func (tmpl *Template) parseBlock(name, padding string, tag *tagReadingResult) (*blockElement, error) {
+	indent := ""
+	if tag.standalone && tmpl.standaloneLine {
+		indent = padding
+	} else if tag.standalone {
+		rest := tmpl.data[tmpl.p:]
+		indent = rest[:len(rest)-len(strings.TrimLeft(rest, " \t"))]
+	}
+
+	tmpl.inheritance = append(tmpl.inheritance, name)
+	defer func() { tmpl.inheritance = tmpl.inheritance[:len(tmpl.inheritance)-1] }()
+
+	se := tmpl.newSection(name, false, tag, tmpl.p)
+	if err := tmpl.parseSection(se); err != nil {
+		return nil, err
+	}
+
+	return &blockElement{
+		name:     name,
+		indent:   indent,
+		elems:    se.elems,
+		tmplName: tmpl.name,
+	}, nil
+}
+
+// newSection creates a section opened by tag, whose content starts at offset
+// start.
+func (tmpl *Template) newSection(name string, inverted bool, tag *tagReadingResult, start int) *sectionElement {
+	return &sectionElement{
+		name:      name,
+		inverted:  inverted,
+		startline: tag.line,
+		column:    tag.column,
+		open:      tag.start,
+		elems:     []interface{}{},
+		start:     start,
+		otag:      tmpl.otag,
+		ctag:      tmpl.ctag,
+	}
+}
+
+// parseFragment parses text produced by a lambda using the given delimiters
+// and the parse settings of tmpl.
+func (tmpl *Template) parseFragment(text, otag, ctag string) (*Template, error) {
+	frag := Template{
+		data:     text,
+		otag:     otag,
+		ctag:     ctag,
+		curline:  1,
+		elems:    []interface{}{},
+		forceRaw: tmpl.forceRaw,
+		partial:  tmpl.partial,
+	}
+	if err := frag.parse(); err != nil {
+		return nil, err
+	}
+	return &frag, nil
+}
+
 func (tmpl *Template) parseSection(section *sectionElement) error {
+	tmpl.open = append(tmpl.open, section.name)
+	defer func() { tmpl.open = tmpl.open[:len(tmpl.open)-1] }()
+
 	for {
 		textResult, err := tmpl.readText()
 		text := textResult.text
@@ -346,7 +897,7 @@ func (tmpl *Template) parseSection(section *sectionElement) error {
 
 		if err == io.EOF {
 			//put the remaining text in a block
-			return newErrorWithReason(section.startline, ErrSectionNoClosingTag, section.name)
+			return tmpl.report(tmpl.parseError(section.open, ErrSectionNoClosingTag, section.name))
 		}
 
 		// put text into an item
@@ -354,7 +905,10 @@ func (tmpl *Template) parseSection(section *sectionElement) error {
 
 		tagResult, err := tmpl.readTag(mayStandalone)
 		if err != nil {
-			return err
+			if err := tmpl.report(err); err != nil {
+				return err
+			}
+			continue
 		}
 
 		if !tagResult.standalone {
@@ -367,28 +921,75 @@ func (tmpl *Template) parseSection(section *sectionElement) error {
 			//ignore comment
 		case '#', '^':
 			name := strings.TrimSpace(tag[1:])
-			se := sectionElement{name, tag[0] == '^', tmpl.curline, []interface{}{}}
-			err := tmpl.parseSection(&se)
-			if err != nil {
+			se := tmpl.newSection(name, tag[0] == '^', tagResult, tagResult.end)
+			if se.expr, err = tmpl.parseTagExpr(name, tagResult); err != nil {
+				if err := tmpl.report(err); err != nil {
+					return err
+				}
+			}
+			if err := tmpl.parseSection(se); err != nil {
 				return err
 			}
-			section.elems = append(section.elems, &se)
+			section.elems = append(section.elems, se)
+			if tmpl.closedBy(section) {
+				return nil
+			}
 		case '/':
 			name := strings.TrimSpace(tag[1:])
 			if name != section.name {
-				return newErrorWithReason(tmpl.curline, ErrInterleavedClosingTag, name)
+				if err := tmpl.report(tmpl.parseError(tagResult.start, ErrInterleavedClosingTag, name)); err != nil {
+					return err
+				}
+				// close the sections up to the one named, or ignore the tag
+				if tmpl.isOpen(name) {
+					tmpl.closing, tmpl.closingStart = name, tagResult.start
+					tmpl.closedBy(section)
+					return nil
+				}
+				continue
 			}
+			section.text = tmpl.data[section.start:tagResult.start]
 			return nil
 		case '>':
 			name := strings.TrimSpace(tag[1:])
-			partial, err := tmpl.parsePartial(name, textResult.padding)
+			partial, err := tmpl.parsePartial(name, textResult.padding, tagResult)
 			if err != nil {
-				return err
+				if err := tmpl.report(err); err != nil {
+					return err
+				}
+				continue
 			}
 			section.elems = append(section.elems, partial)
+		case '<':
+			name := strings.TrimSpace(tag[1:])
+			indent := ""
+			if tagResult.standalone {
+				indent = padding
+			}
+			parent, err := tmpl.parseParent(name, indent, tagResult)
+			if err != nil {
+				return err
+			}
+			section.elems = append(section.elems, parent)
+			if tmpl.closedBy(section) {
+				return nil
+			}
+		case '$':
+			name := strings.TrimSpace(tag[1:])
+			block, err := tmpl.parseBlock(name, padding, tagResult)
+			if err != nil {
+				return err
+			}
+			section.elems = append(section.elems, block)
+			if tmpl.closedBy(section) {
+				return nil
+			}
 		case '=':
 			if tag[len(tag)-1] != '=' {
-				return newError(tmpl.curline, ErrInvalidMetaTag)
+				if err := tmpl.report(tmpl.parseError(tagResult.start, ErrInvalidMetaTag, "")); err != nil {
+					return err
+				}
+				continue
 			}
 			tag = strings.TrimSpace(tag[1 : len(tag)-1])
 			newtags := strings.SplitN(tag, " ", 2)
@@ -396,17 +997,17 @@ func (tmpl *Template) parseSection(section *sectionElement) error {
 				tmpl.otag = newtags[0]
 				tmpl.ctag = newtags[1]
 			}
-		case '{':
-			if tag[len(tag)-1] == '}' {
-				//use a raw tag
-				name := strings.TrimSpace(tag[1 : len(tag)-1])
-				section.elems = append(section.elems, &varElement{name, true})
-			}
-		case '&':
-			name := strings.TrimSpace(tag[1:])
-			section.elems = append(section.elems, &varElement{name, true})
 		default:
-			section.elems = append(section.elems, &varElement{tag, tmpl.forceRaw})
+			elem, err := tmpl.parseVar(tag, tagResult)
+			if err != nil {
+				if err := tmpl.report(err); err != nil {
+					return err
+				}
+				continue
+			}
+			if elem != nil {
+				section.elems = append(section.elems, elem)
+			}
 		}
 	}
 }
@@ -421,6 +1022,14 @@ func (tmpl *Template) parse() error {
 		if err == io.EOF {
 			//put the remaining text in a block
 			tmpl.elems = append(tmpl.elems, &textElement{[]byte(text)})
+			if len(tmpl.errs) > 0 {
+				// errors in sections are found when they close, so they
+				// are ordered by their position instead
+				sort.SliceStable(tmpl.errs, func(i, j int) bool {
+					return tmpl.errs[i].Offset < tmpl.errs[j].Offset
+				})
+				return ParseErrors(tmpl.errs)
+			}
 			return nil
 		}
 
@@ -429,7 +1038,10 @@ func (tmpl *Template) parse() error {
 
 		tagResult, err := tmpl.readTag(mayStandalone)
 		if err != nil {
-			return err
+			if err := tmpl.report(err); err != nil {
+				return err
+			}
+			continue
 		}
 
 		if !tagResult.standalone {
@@ -442,24 +1054,54 @@ func (tmpl *Template) parse() error {
 			//ignore comment
 		case '#', '^':
 			name := strings.TrimSpace(tag[1:])
-			se := sectionElement{name, tag[0] == '^', tmpl.curline, []interface{}{}}
-			err := tmpl.parseSection(&se)
-			if err != nil {
+			se := tmpl.newSection(name, tag[0] == '^', tagResult, tagResult.end)
+			if se.expr, err = tmpl.parseTagExpr(name, tagResult); err != nil {
+				if err := tmpl.report(err); err != nil {
+					return err
+				}
+			}
+			if err := tmpl.parseSection(se); err != nil {
 				return err
 			}
-			tmpl.elems = append(tmpl.elems, &se)
+			tmpl.elems = append(tmpl.elems, se)
 		case '/':
-			return newError(tmpl.curline, ErrUnmatchedCloseTag)
+			if err := tmpl.report(tmpl.parseError(tagResult.start, ErrUnmatchedCloseTag, "")); err != nil {
+				return err
+			}
 		case '>':
 			name := strings.TrimSpace(tag[1:])
-			partial, err := tmpl.parsePartial(name, textResult.padding)
+			partial, err := tmpl.parsePartial(name, textResult.padding, tagResult)
 			if err != nil {
-				return err
+				if err := tmpl.report(err); err != nil {
+					return err
+				}
+				continue
 			}
 			tmpl.elems = append(tmpl.elems, partial)
+		case '<':
+			name := strings.TrimSpace(tag[1:])
+			indent := ""
+			if tagResult.standalone {
+				indent = padding
+			}
+			parent, err := tmpl.parseParent(name, indent, tagResult)
+			if err != nil {
+				return err
+			}
+			tmpl.elems = append(tmpl.elems, parent)
+		case '$':
+			name := strings.TrimSpace(tag[1:])
+			block, err := tmpl.parseBlock(name, padding, tagResult)
+			if err != nil {
+				return err
+			}
+			tmpl.elems = append(tmpl.elems, block)
 		case '=':
 			if tag[len(tag)-1] != '=' {
-				return newError(tmpl.curline, ErrInvalidMetaTag)
+				if err := tmpl.report(tmpl.parseError(tagResult.start, ErrInvalidMetaTag, "")); err != nil {
+					return err
+				}
+				continue
 			}
 			tag = strings.TrimSpace(tag[1 : len(tag)-1])
 			newtags := strings.SplitN(tag, " ", 2)
@@ -467,23 +1109,23 @@ func (tmpl *Template) parse() error {
 				tmpl.otag = newtags[0]
 				tmpl.ctag = newtags[1]
 			}
-		case '{':
-			//use a raw tag
-			if tag[len(tag)-1] == '}' {
-				name := strings.TrimSpace(tag[1 : len(tag)-1])
-				tmpl.elems = append(tmpl.elems, &varElement{name, true})
-			}
-		case '&':
-			name := strings.TrimSpace(tag[1:])
-			tmpl.elems = append(tmpl.elems, &varElement{name, true})
 		default:
-			tmpl.elems = append(tmpl.elems, &varElement{tag, tmpl.forceRaw})
+			elem, err := tmpl.parseVar(tag, tagResult)
+			if err != nil {
+				if err := tmpl.report(err); err != nil {
+					return err
+				}
+				continue
+			}
+			if elem != nil {
+				tmpl.elems = append(tmpl.elems, elem)
+			}
 		}
 	}
 }
 
-func lookupAllowMissing(contextChain []interface{}, name string, allowMissing bool) (reflect.Value, error) {
-	value, err := lookup(contextChain, nil, name)
+func lookupAllowMissing(rs *renderState, contextChain []interface{}, e expr, allowMissing bool) (reflect.Value, error) {
+	value, err := lookup(rs, contextChain, e)
 	if err != nil && allowMissing {
 		if IsMissingVariableError(err) {
 			return reflect.Value{}, nil
@@ -512,6 +1154,12 @@ func isEmpty(v reflect.Value) bool {
 	}
 }
 
+// isNil reports whether v is nil, or a chain of pointers and interfaces
+// ending in nil.
+func isNil(v reflect.Value) bool {
+	return !indirect(v).IsValid()
+}
+
 func indirect(v reflect.Value) reflect.Value {
 loop:
 	for v.IsValid() {
@@ -527,13 +1175,15 @@ loop:
 	return v
 }
 
-func (tmpl *Template) renderSection(section *sectionElement, contextChain []interface{}, buf io.Writer) error {
-	value, err := lookupAllowMissing(contextChain, section.name, true)
+func (tmpl *Template) renderSection(rs *renderState, section *sectionElement, contextChain []interface{}, buf io.Writer) error {
+	value, err := lookupAllowMissing(rs, contextChain, section.expr, true)
 	if err != nil {
 		return err
 	}
 	var context = contextChain[0].(reflect.Value)
 	var contexts = []interface{}{}
+	// iterated is set when the section is rendered for each item of a list
+	iterated := false
 	// if the value is nil, check if it's an inverted section
 	isEmpty := isEmpty(value)
 	if isEmpty && !section.inverted || !isEmpty && section.inverted {
@@ -545,33 +1195,33 @@ func (tmpl *Template) renderSection(section *sectionElement, contextChain []inte
 			for i := 0; i < val.Len(); i++ {
 				contexts = append(contexts, val.Index(i))
 			}
+			iterated = true
 		case reflect.Array:
 			for i := 0; i < val.Len(); i++ {
 				contexts = append(contexts, val.Index(i))
 			}
+			iterated = true
 		case reflect.Map, reflect.Struct:
 			contexts = append(contexts, value)
 		case reflect.Func:
-			if val.Type().NumIn() != 2 || val.Type().NumOut() != 2 {
+			if !acceptsArgs(val.Type(), 2) || val.Type().NumOut() != 2 {
 				return fmt.Errorf("lambda %q doesn't match required LambaFunc signature", section.name)
 			}
-			var text bytes.Buffer
-			if err := getSectionText(section.elems, &text); err != nil {
-				return err
-			}
 			render := func(text string) (string, error) {
-				tmpl, err := ParseString(text)
+				frag, err := tmpl.parseFragment(text, section.otag, section.ctag)
 				if err != nil {
 					return "", err
 				}
-				var buf bytes.Buffer
-				if err := tmpl.renderTemplate(contextChain, &buf); err != nil {
-					return "", err
-				}
-				return buf.String(), nil
+				return tmpl.renderFragment(rs, frag, contextChain)
+			}
+			if err := rs.checkFunc(section.name); err != nil {
+				return err
+			}
+			in := []reflect.Value{reflect.ValueOf(section.text), reflect.ValueOf(render)}
+			res, err := rs.call(val, in)
+			if err != nil {
+				return err
 			}
-			in := []reflect.Value{reflect.ValueOf(text.String()), reflect.ValueOf(render)}
-			res := val.Call(in)
 			if !res[1].IsNil() {
 				return fmt.Errorf("lambda %q: %w", section.name, res[1].Interface().(error))
 			}
@@ -590,77 +1240,74 @@ func (tmpl *Template) renderSection(section *sectionElement, contextChain []inte
 	chain2 := make([]interface{}, len(contextChain)+1)
 	copy(chain2[1:], contextChain)
 	//by default we execute the section
-	for _, ctx := range contexts {
-		chain2[0] = ctx
-		for _, elem := range section.elems {
-			if err := tmpl.renderElement(elem, chain2, buf); err != nil {
-				return err
-			}
+	for i, ctx := range contexts {
+		rs.line, rs.column = section.startline, section.column
+		if iterated {
+			rs.path = append(rs.path, pathItem{section.name, i})
 		}
-	}
-	return nil
-}
-
-func getSectionText(elements []interface{}, buf io.Writer) error {
-	for _, element := range elements {
-		if err := getElementText(element, buf); err != nil {
+		err := tmpl.renderItem(rs, section, chain2, ctx, buf)
+		if iterated {
+			rs.path = rs.path[:len(rs.path)-1]
+		}
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func getElementText(element interface{}, buf io.Writer) error {
-	switch elem := element.(type) {
-	case *textElement:
-		fmt.Fprintf(buf, "%s", elem.text)
-	case *varElement:
-		if elem.raw {
-			fmt.Fprintf(buf, "{{{%s}}}", elem.name)
-		} else {
-			fmt.Fprintf(buf, "{{%s}}", elem.name)
-		}
-	case *sectionElement:
-		if elem.inverted {
-			fmt.Fprintf(buf, "{{^%s}}", elem.name)
-		} else {
-			fmt.Fprintf(buf, "{{#%s}}", elem.name)
-		}
-		for _, nelem := range elem.elems {
-			if err := getElementText(nelem, buf); err != nil {
-				return err
-			}
-		}
-		fmt.Fprintf(buf, "{{/%s}}", elem.name)
-	default:
-		return fmt.Errorf("unexpected element type %T", elem)
+// renderItem renders the content of section for one of its items, ctx.
+// Errors found before the content is rendered are wrapped here, while the
+// item is part of the render's path.
+func (tmpl *Template) renderItem(rs *renderState, section *sectionElement, contextChain []interface{}, ctx interface{}, buf io.Writer) error {
+	if err := rs.ctx.Err(); err != nil {
+		return rs.wrap(err)
 	}
-	return nil
+	rs.iterations++
+	if max := rs.limits.MaxIterations; max > 0 && rs.iterations > max {
+		return rs.wrap(newLimitError(ErrIterationLimit, max))
+	}
+	contextChain[0] = ctx
+	return tmpl.renderElements(rs, section.elems, contextChain, buf)
 }
 
-func (tmpl *Template) renderElement(element interface{}, contextChain []interface{}, buf io.Writer) error {
+func (tmpl *Template) renderElement(rs *renderState, element interface{}, contextChain []interface{}, buf io.Writer) error {
 	switch elem := element.(type) {
 	case *textElement:
 		_, err := buf.Write(elem.text)
 		return err
 	case *varElement:
-		defer func() {
-			if r := recover(); r != nil {
-				fmt.Printf("Panic while looking up %q: %s\n", elem.name, r)
-			}
-		}()
-		val, err := lookupAllowMissing(contextChain, elem.name, AllowMissingVariables)
+		val, err := lookupAllowMissing(rs, contextChain, elem.expr, tmpl.allowMissingVariables())
 		if err != nil {
 			return err
 		}
 
-		if val.IsValid() {
+		if isInterpolationLambda(val) {
+			s, err := tmpl.renderInterpolationLambda(rs, elem.name, indirect(val), contextChain)
+			if err != nil {
+				return err
+			}
+			val = reflect.ValueOf(s)
+		}
+
+		// nil values are left to the formatter, if any, unless the nil
+		// policy says otherwise
+		if val.IsValid() && isNil(val) && (tmpl.formatter == nil || tmpl.nilPolicy != NilEmpty) {
+			switch tmpl.nilPolicy {
+			case NilError:
+				return newNilValueError(elem.name)
+			case NilPlaceholder:
+				_, _ = buf.Write([]byte(tmpl.nilPlaceholder))
+			}
+		} else if val.IsValid() {
 			if tmpl.formatter != nil {
 				s, err := tmpl.formatter(val.Interface())
 				if err != nil {
 					return err
 				}
 				_, _ = buf.Write([]byte(s))
+			} else if err := rs.checkFormat(val); err != nil {
+				return err
 			} else if elem.raw {
 				fmt.Fprint(buf, val.Interface())
 			} else {
@@ -669,39 +1316,290 @@ func (tmpl *Template) renderElement(element interface{}, contextChain []interfac
 			}
 		}
 	case *sectionElement:
-		if err := tmpl.renderSection(elem, contextChain, buf); err != nil {
+		if err := tmpl.renderSection(rs, elem, contextChain, buf); err != nil {
 			return err
 		}
 	case *partialElement:
-		partial, err := getPartials(elem.prov, elem.name, elem.indent)
+		name := elem.name
+		if elem.dynamic {
+			val, err := lookupAllowMissing(rs, contextChain, elem.expr, tmpl.allowMissingVariables())
+			if err != nil {
+				return err
+			}
+			if isNil(val) {
+				return nil
+			}
+			if err := rs.checkFormat(indirect(val)); err != nil {
+				return err
+			}
+			name = fmt.Sprint(indirect(val).Interface())
+		}
+		partial, err := tmpl.getPartial(elem.prov, name, elem.indent, elem.tmplName, elem.line)
+		if err != nil {
+			return err
+		}
+		if err := rs.enter(); err != nil {
+			return err
+		}
+		leave := rs.include(partial.name)
+		err = tmpl.renderElements(rs, partial.elems, contextChain, buf)
+		leave()
+		rs.depth--
+		if err != nil {
+			return err
+		}
+	case *parentElement:
+		parent, err := tmpl.getPartial(elem.prov, elem.name, elem.indent, elem.tmplName, elem.line)
 		if err != nil {
 			return err
 		}
-		if err := partial.renderTemplate(contextChain, buf); err != nil {
+		if err := rs.enter(); err != nil {
+			return err
+		}
+		leave := rs.include(parent.name)
+		rs.blocks = append(rs.blocks, elem.blocks)
+		err = tmpl.renderElements(rs, parent.elems, contextChain, buf)
+		rs.blocks = rs.blocks[:len(rs.blocks)-1]
+		leave()
+		rs.depth--
+		if err != nil {
+			return err
+		}
+	case *blockElement:
+		if err := tmpl.renderBlock(rs, elem, contextChain, buf); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (tmpl *Template) renderTemplate(contextChain []interface{}, buf io.Writer) error {
-	for _, elem := range tmpl.elems {
-		if err := tmpl.renderElement(elem, contextChain, buf); err != nil {
-			return err
+// getPartial returns the compiled partial for a partial or parent tag found
+// in template tmplName at the given line. Partials which cannot be found are
+// empty, unless the template's policy for missing partials is MissingError.
+func (tmpl *Template) getPartial(prov PartialProvider, name, indent, tmplName string, line int) (*Template, error) {
+	partial, err := tmpl.cache.get(prov, name, indent, tmpl.forceRaw)
+	if errors.Is(err, ErrPartialNotFound) {
+		if tmpl.missingPartials == MissingError {
+			return nil, newMissingPartialError(name, tmplName, line)
+		}
+		return &Template{}, nil
+	}
+	return partial, err
+}
+
+// CheckPartials verifies that all the partials and parents referenced by the
+// template, directly or through other partials, can be found. It returns a
+// MissingPartialError for each one which cannot. Dynamic partial names are
+// not checked, since they are only known when rendering.
+func (tmpl *Template) CheckPartials() error {
+	var errs []error
+	seen := map[partialKey]bool{}
+	var check func(elems []interface{})
+	check = func(elems []interface{}) {
+		for _, elem := range elems {
+			var prov PartialProvider
+			var name, indent, tmplName string
+			var line int
+			switch elem := elem.(type) {
+			case *sectionElement:
+				check(elem.elems)
+				continue
+			case *blockElement:
+				check(elem.elems)
+				continue
+			case *parentElement:
+				for _, block := range elem.blocks {
+					check(block.elems)
+				}
+				prov, name, indent, tmplName, line = elem.prov, elem.name, elem.indent, elem.tmplName, elem.line
+			case *partialElement:
+				if elem.dynamic {
+					continue
+				}
+				prov, name, indent, tmplName, line = elem.prov, elem.name, elem.indent, elem.tmplName, elem.line
+			default:
+				continue
+			}
+
+			partial, err := tmpl.cache.get(prov, name, indent, tmpl.forceRaw)
+			if errors.Is(err, ErrPartialNotFound) {
+				errs = append(errs, newMissingPartialError(name, tmplName, line))
+				continue
+			} else if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			// providers which cannot be told apart are the same one here
+			key, ok := newPartialKey(prov, name, indent)
+			if !ok {
+				key = partialKey{nil, name, indent}
+			}
+			if !seen[key] {
+				seen[key] = true
+				check(partial.elems)
+			}
+		}
+	}
+	check(tmpl.elems)
+	return errors.Join(errs...)
+}
+
+// renderBlock renders the override for a block if one was given by an
+// enclosing parent tag, and the block's own content otherwise. Overrides are
+// re-indented to match the indentation of the block they replace.
+func (tmpl *Template) renderBlock(rs *renderState, block *blockElement, contextChain []interface{}, buf io.Writer) error {
+	override := rs.override(block.name)
+	if override == nil {
+		return tmpl.renderElements(rs, block.elems, contextChain, buf)
+	}
+	// the override comes from the template holding the parent tag
+	name := rs.name
+	rs.name = override.tmplName
+	defer func() { rs.name = name }()
+	if override.indent == block.indent {
+		return tmpl.renderElements(rs, override.elems, contextChain, buf)
+	}
+
+	text, w := rs.buffer()
+	if err := tmpl.renderElements(rs, override.elems, contextChain, w); err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(text.String(), "\n")
+	for i, line := range lines {
+		if line != "" && line != "\n" {
+			lines[i] = block.indent + strings.TrimPrefix(line, override.indent)
+		}
+	}
+	_, err := io.WriteString(buf, strings.Join(lines, ""))
+	return err
+}
+
+// isInterpolationLambda reports whether v holds a lambda which can be used in
+// a variable tag, i.e. a func() string or func() (string, error), optionally
+// taking a context.Context.
+func isInterpolationLambda(v reflect.Value) bool {
+	fn := indirect(v)
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return false
+	}
+	typ := fn.Type()
+	if !acceptsArgs(typ, 0) || typ.NumOut() < 1 || typ.NumOut() > 2 || typ.Out(0).Kind() != reflect.String {
+		return false
+	}
+	return typ.NumOut() == 1 || typ.Out(1) == reflect.TypeOf((*error)(nil)).Elem()
+}
+
+// renderInterpolationLambda calls the lambda of a variable tag and renders the
+// returned text as a template. As required by the spec, the text is parsed
+// using the default delimiters rather than the ones active at the tag.
+func (tmpl *Template) renderInterpolationLambda(rs *renderState, name string, fn reflect.Value, contextChain []interface{}) (string, error) {
+	if err := rs.checkFunc(name); err != nil {
+		return "", err
+	}
+	res, err := rs.call(fn, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(res) == 2 && !res[1].IsNil() {
+		return "", fmt.Errorf("lambda %q: %w", name, res[1].Interface().(error))
+	}
+
+	lambda, err := tmpl.parseFragment(res[0].String(), "{{", "}}")
+	if err != nil {
+		return "", err
+	}
+	return tmpl.renderFragment(rs, lambda, contextChain)
+}
+
+// renderFragment renders a fragment parsed from the text of a lambda. The
+// positions of its tags are relative to the text, not to the template, so
+// errors found in the fragment itself are returned without them, to be
+// reported at the position of the lambda's tag, which is restored afterwards.
+func (tmpl *Template) renderFragment(rs *renderState, frag *Template, contextChain []interface{}) (string, error) {
+	line, column, includes := rs.line, rs.column, len(rs.includes)
+	defer func() { rs.line, rs.column = line, column }()
+	buf, w := rs.buffer()
+	if err := tmpl.renderElements(rs, frag.elems, contextChain, w); err != nil {
+		if renderErr, ok := err.(RenderError); ok && len(renderErr.Includes) == includes {
+			return "", renderErr.Err
+		}
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (tmpl *Template) renderElements(rs *renderState, elems []interface{}, contextChain []interface{}, buf io.Writer) error {
+	for _, elem := range elems {
+		if line, column := elementPos(elem); line > 0 {
+			rs.line, rs.column = line, column
+		}
+		if err := rs.ctx.Err(); err != nil {
+			return rs.wrap(err)
+		}
+		if err := tmpl.renderElement(rs, elem, contextChain, buf); err != nil {
+			return rs.wrap(err)
+		}
+		if rs.out != nil && rs.out.err != nil {
+			return rs.wrap(rs.out.err)
 		}
 	}
 	return nil
 }
 
+func (tmpl *Template) renderTemplate(rs *renderState, contextChain []interface{}, buf io.Writer) error {
+	return tmpl.renderElements(rs, tmpl.elems, contextChain, buf)
+}
+
+// elementPos returns the line and column of the tag an element was parsed
+// from, or 0 for text and blocks.
+func elementPos(elem interface{}) (line, column int) {
+	switch elem := elem.(type) {
+	case *varElement:
+		return elem.line, elem.column
+	case *sectionElement:
+		return elem.startline, elem.column
+	case *partialElement:
+		return elem.line, elem.column
+	case *parentElement:
+		return elem.line, elem.column
+	}
+	return 0, 0
+}
+
 // FRender uses the given data source - generally a map or struct - to
 // render the compiled template to an io.Writer.
-func (tmpl *Template) FRender(out io.Writer, context ...interface{}) error {
+func (tmpl *Template) FRender(out io.Writer, data ...interface{}) error {
+	return tmpl.FRenderContext(context.Background(), out, data...)
+}
+
+// FRenderContext is like FRender, but stops rendering when ctx is done,
+// returning the context's error wrapped with the position reached in the
+// template. Cancellation is checked before each tag and each iteration of a
+// section. Lambdas, methods and functions called while rendering receive ctx
+// when their first parameter is a context.Context.
+//
+// A panic raised while rendering, such as by a method of the data, is
+// recovered and returned as a PanicError wrapped with the position of the tag
+// which raised it, unless the template was parsed with WithPanics.
+func (tmpl *Template) FRenderContext(ctx context.Context, out io.Writer, data ...interface{}) (err error) {
 	var contextChain []interface{}
-	for _, c := range context {
+	for _, c := range data {
 		val := reflect.ValueOf(c)
 		contextChain = append(contextChain, val)
 	}
-	return tmpl.renderTemplate(contextChain, out)
+	rs := &renderState{ctx: ctx, name: tmpl.name, limits: tmpl.limits, sandbox: tmpl.sandbox, funcs: tmpl.funcs, tags: tmpl.tags, match: tmpl.match, allowMissing: tmpl.allowMissingVariables()}
+	if max := tmpl.limits.MaxOutputBytes; max > 0 {
+		rs.out = &limitWriter{w: out, max: max}
+		out = rs.out
+	}
+	if !tmpl.panics {
+		defer func() {
+			if r := recover(); r != nil {
+				err = rs.wrap(newPanicError(r))
+			}
+		}()
+	}
+	return tmpl.renderTemplate(rs, contextChain, out)
 }
 
 // Render uses the given data source - generally a map or struct - to render
@@ -740,57 +1638,52 @@ func (tmpl *Template) FRenderInLayout(out io.Writer, layout *Template, context .
 
 // ParseString compiles a mustache template string. The resulting output can
 // be used to efficiently render the template multiple times with different data
-// sources.
-func ParseString(data string) (*Template, error) {
-	return ParseStringRaw(data, false)
+// sources. Partials are loaded from the directory given by the CWD environment
+// variable, unless another provider is given with WithPartials.
+func ParseString(data string, opts ...Option) (*Template, error) {
+	cwd := os.Getenv("CWD")
+	partials := &FileProvider{
+		Paths: []string{cwd, " "},
+	}
+
+	return parseTemplate("", data, append([]Option{WithPartials(partials)}, opts...))
 }
 
 // ParseStringRaw compiles a mustache template string. The resulting output can
 // be used to efficiently render the template multiple times with different data
 // sources.
+//
+// Deprecated: Use ParseString with WithRaw.
 func ParseStringRaw(data string, forceRaw bool) (*Template, error) {
-	cwd := os.Getenv("CWD")
-	partials := &FileProvider{
-		Paths: []string{cwd, " "},
-	}
-
-	return ParseStringPartialsRaw(data, partials, forceRaw)
+	return ParseString(data, rawOptions(forceRaw)...)
 }
 
 // ParseStringWithFormatter compiles a mustache template string. The resulting output can
 // be used to efficiently render the template multiple times with different data
 // sources.
 // The formatter function is used to format the output of the template.
+//
+// Deprecated: Use ParseString with WithFormatter.
 func ParseStringWithFormatter(data string, formatter FormatterFunc) (*Template, error) {
-	cwd := os.Getenv("CWD")
-	partials := &FileProvider{
-		Paths: []string{cwd, " "},
-	}
-
-	return ParseStringPartialsWithFormatter(data, partials, formatter)
+	return ParseString(data, WithRaw(), WithFormatter(formatter))
 }
 
 // ParseStringPartials compiles a mustache template string, retrieving any
 // required partials from the given provider. The resulting output can be used
 // to efficiently render the template multiple times with different data
 // sources.
-func ParseStringPartials(data string, partials PartialProvider) (*Template, error) {
-	return ParseStringPartialsRaw(data, partials, false)
+func ParseStringPartials(data string, partials PartialProvider, opts ...Option) (*Template, error) {
+	return parseTemplate("", data, append([]Option{WithPartials(partials)}, opts...))
 }
 
 // ParseStringPartialsRaw compiles a mustache template string, retrieving any
 // required partials from the given provider. The resulting output can be used
 // to efficiently render the template multiple times with different data
 // sources.
+//
+// Deprecated: Use ParseString with WithPartials and WithRaw.
 func ParseStringPartialsRaw(data string, partials PartialProvider, forceRaw bool) (*Template, error) {
-	tmpl := Template{data, "{{", "}}", 0, 1, []interface{}{}, forceRaw, partials, template.HTMLEscapeString, nil}
-	err := tmpl.parse()
-
-	if err != nil {
-		return nil, err
-	}
-
-	return &tmpl, err
+	return ParseStringPartials(data, partials, rawOptions(forceRaw)...)
 }
 
 // ParseStringPartialsWithFormatter compiles a mustache template string, retrieving any
@@ -798,70 +1691,56 @@ func ParseStringPartialsRaw(data string, partials PartialProvider, forceRaw bool
 // to efficiently render the template multiple times with different data
 // sources.
 // The formatter function is used to format the output of the template.
-
+//
+// Deprecated: Use ParseString with WithPartials and WithFormatter.
 func ParseStringPartialsWithFormatter(data string, partials PartialProvider, formatter FormatterFunc) (*Template, error) {
-	tmpl := Template{data, "{{", "}}", 0, 1, []interface{}{}, true, partials, template.HTMLEscapeString, formatter}
-	err := tmpl.parse()
-
-	if err != nil {
-		return nil, err
-	}
-
-	return &tmpl, err
+	return ParseStringPartials(data, partials, WithRaw(), WithFormatter(formatter))
 }
 
 // ParseFile loads a mustache template string from a file and compiles it. The
 // resulting output can be used to efficiently render the template multiple
-// times with different data sources.
-func ParseFile(filename string) (*Template, error) {
+// times with different data sources. Partials are loaded from the directory of
+// the file, unless another provider is given with WithPartials.
+func ParseFile(filename string, opts ...Option) (*Template, error) {
 	dirname, _ := path.Split(filename)
 	partials := &FileProvider{
 		Paths: []string{dirname, " "},
 	}
 
-	return ParseFilePartials(filename, partials)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTemplate(filename, string(data), append([]Option{WithPartials(partials)}, opts...))
 }
 
 // ParseFilePartials loads a mustache template string from a file, retrieving any
 // required partials from the given provider, and compiles it. The resulting
 // output can be used to efficiently render the template multiple times with
 // different data sources.
-func ParseFilePartials(filename string, partials PartialProvider) (*Template, error) {
-	return ParseFilePartialsRaw(filename, false, partials)
+func ParseFilePartials(filename string, partials PartialProvider, opts ...Option) (*Template, error) {
+	return ParseFile(filename, append([]Option{WithPartials(partials)}, opts...)...)
 }
 
 // ParseFileWithFormatter loads a mustache template string from a file and compiles it. The
 // resulting output can be used to efficiently render the template multiple
 // times with different data sources.
 // The formatter function is used to format the output of the template.
-
+//
+// Deprecated: Use ParseFile with WithFormatter.
 func ParseFileWithFormatter(filename string, formatter FormatterFunc) (*Template, error) {
-	dirname, _ := path.Split(filename)
-	partials := &FileProvider{
-		Paths: []string{dirname, " "},
-	}
-
-	return ParseFilePartialsWithFormatter(filename, partials, formatter)
+	return ParseFile(filename, WithRaw(), WithFormatter(formatter))
 }
 
 // ParseFilePartialsRaw loads a mustache template string from a file, retrieving
 // any required partials from the given provider, and compiles it. The resulting
 // output can be used to efficiently render the template multiple times with
 // different data sources.
+//
+// Deprecated: Use ParseFile with WithPartials and WithRaw.
 func ParseFilePartialsRaw(filename string, forceRaw bool, partials PartialProvider) (*Template, error) {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-
-	tmpl := Template{string(data), "{{", "}}", 0, 1, []interface{}{}, forceRaw, partials, template.HTMLEscapeString, nil}
-	err = tmpl.parse()
-
-	if err != nil {
-		return nil, err
-	}
-
-	return &tmpl, nil
+	return ParseFilePartials(filename, partials, rawOptions(forceRaw)...)
 }
 
 // ParseFilePartialsWithFormatter loads a mustache template string from a file, retrieving
@@ -869,16 +1748,55 @@ func ParseFilePartialsRaw(filename string, forceRaw bool, partials PartialProvid
 // output can be used to efficiently render the template multiple times with
 // different data sources.
 // The formatter function is used to format the output of the template.
+//
+// Deprecated: Use ParseFile with WithPartials and WithFormatter.
 func ParseFilePartialsWithFormatter(filename string, partials PartialProvider, formatter FormatterFunc) (*Template, error) {
-	data, err := os.ReadFile(filename)
+	return ParseFilePartials(filename, partials, WithRaw(), WithFormatter(formatter))
+}
+
+// ParseFS loads a mustache template string from a file in fsys and compiles it.
+// The resulting output can be used to efficiently render the template multiple
+// times with different data sources. Partials are searched for in the directory
+// of the file, unless another provider is given with WithPartials.
+func ParseFS(fsys fs.FS, name string, opts ...Option) (*Template, error) {
+	partials := &FSProvider{
+		FS:    fsys,
+		Paths: []string{path.Dir(name)},
+	}
+
+	data, err := fs.ReadFile(fsys, name)
 	if err != nil {
 		return nil, err
 	}
 
-	tmpl := Template{string(data), "{{", "}}", 0, 1, []interface{}{}, true, partials, template.HTMLEscapeString, formatter}
-	err = tmpl.parse()
+	return parseTemplate(name, string(data), append([]Option{WithPartials(partials)}, opts...))
+}
 
-	if err != nil {
+// ParseFSPartials loads a mustache template string from a file in fsys,
+// retrieving any required partials from the given provider, and compiles it.
+// The resulting output can be used to efficiently render the template multiple
+// times with different data sources.
+func ParseFSPartials(fsys fs.FS, name string, partials PartialProvider, opts ...Option) (*Template, error) {
+	return ParseFS(fsys, name, append([]Option{WithPartials(partials)}, opts...)...)
+}
+
+// parseTemplate compiles the named template data, configured by opts.
+func parseTemplate(name, data string, opts []Option) (*Template, error) {
+	tmpl := Template{
+		data:    data,
+		otag:    "{{",
+		ctag:    "}}",
+		curline: 1,
+		elems:   []interface{}{},
+		escape:  template.HTMLEscapeString,
+		cache:   newPartialCache(),
+		name:    name,
+		tags:    defaultTags,
+	}
+	for _, opt := range opts {
+		opt(&tmpl)
+	}
+	if err := tmpl.parse(); err != nil {
 		return nil, err
 	}
 
@@ -888,12 +1806,14 @@ func ParseFilePartialsWithFormatter(filename string, partials PartialProvider, f
 // Render compiles a mustache template string and uses the the given data source
 // - generally a map or struct - to render the template and return the output.
 func Render(data string, context ...interface{}) (string, error) {
-	return RenderRaw(data, false, context...)
+	return RenderPartials(data, nil, context...)
 }
 
 // RenderRaw compiles a mustache template string and uses the the given data
 // source - generally a map or struct - to render the template and return the
 // output.
+//
+// Deprecated: Use ParseString with WithRaw, and Template.Render.
 func RenderRaw(data string, forceRaw bool, context ...interface{}) (string, error) {
 	return RenderPartialsRaw(data, nil, forceRaw, context...)
 }
@@ -902,6 +1822,8 @@ func RenderRaw(data string, forceRaw bool, context ...interface{}) (string, erro
 // provider and data source - generally a map or struct - to render the template
 // and return the output.
 // The formatter function is used to format the output of the template.
+//
+// Deprecated: Use ParseString with WithFormatter, and Template.Render.
 func RenderWithFormatter(data string, formatter FormatterFunc, context ...interface{}) (string, error) {
 	return RenderPartialsWithFormatter(data, nil, formatter, context...)
 }
@@ -910,42 +1832,40 @@ func RenderWithFormatter(data string, formatter FormatterFunc, context ...interf
 // provider and data source - generally a map or struct - to render the template
 // and return the output.
 func RenderPartials(data string, partials PartialProvider, context ...interface{}) (string, error) {
-	return RenderPartialsRaw(data, partials, false, context...)
+	return renderString(data, partials, nil, context)
 }
 
 // RenderPartialsRaw compiles a mustache template string and uses the the given
 // partial provider and data source - generally a map or struct - to render the
 // template and return the output.
+//
+// Deprecated: Use ParseString with WithPartials and WithRaw, and
+// Template.Render.
 func RenderPartialsRaw(data string, partials PartialProvider, forceRaw bool, context ...interface{}) (string, error) {
-	var tmpl *Template
-	var err error
-	if partials == nil {
-		tmpl, err = ParseStringRaw(data, forceRaw)
-	} else {
-		tmpl, err = ParseStringPartialsRaw(data, partials, forceRaw)
-	}
-	if err != nil {
-		return "", err
-	}
-	return tmpl.Render(context...)
+	return renderString(data, partials, rawOptions(forceRaw), context)
 }
 
 // RenderPartialsWithFormatter compiles a mustache template string and uses the the given partial
 // provider and data source - generally a map or struct - to render the template
 // and return the output.
 // The formatter function is used to format the output of the template.
+//
+// Deprecated: Use ParseString with WithPartials and WithFormatter, and
+// Template.Render.
 func RenderPartialsWithFormatter(data string, partials PartialProvider, formatter FormatterFunc, context ...interface{}) (string, error) {
-	var tmpl *Template
-	var err error
-	if partials == nil {
-		tmpl, err = ParseStringWithFormatter(data, formatter)
-	} else {
-		tmpl, err = ParseStringPartialsWithFormatter(data, partials, formatter)
+	return renderString(data, partials, []Option{WithRaw(), WithFormatter(formatter)}, context)
+}
+
+// renderString compiles a mustache template string, with the given partial
+// provider if it is not nil, and renders it.
+func renderString(data string, partials PartialProvider, opts []Option, context []interface{}) (string, error) {
+	if partials != nil {
+		opts = append(opts, WithPartials(partials))
 	}
+	tmpl, err := ParseString(data, opts...)
 	if err != nil {
 		return "", err
 	}
-	tmpl.formatter = formatter
 	return tmpl.Render(context...)
 }
 
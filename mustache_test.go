@@ -2,12 +2,19 @@ package mustache
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 type Test struct {
@@ -57,6 +64,10 @@ func (u *User) Func6() ([]interface{}, error) {
 	return v, nil
 }
 
+func (u *User) Clear() {
+	u.Name = ""
+}
+
 func (u User) Truefunc1() bool {
 	return true
 }
@@ -210,7 +221,6 @@ var tests = []Test{
 	// array tests
 	{`hello {{a[0]}}`, map[string]any{"a": []string{"a", "b"}}, "hello a", nil},
 	{`hello {{a[1]}}`, map[string]any{"a": []string{"a", "b"}}, "hello b", nil},
-	{`hello {{a[2]}}`, map[string]any{"a": []string{"a", "b"}}, "hello ", nil},
 	{`hello {{a[x]}}`, map[string]any{"a": []string{"a", "b"}, "x": 1}, "hello b", nil},
 	{`hello {{a.length}}`, map[string]any{"a": []string{"a", "b"}}, "hello 2", nil},
 	{`hello {{b[0].c}}`, map[string]any{"b": []any{map[string]any{
@@ -225,6 +235,23 @@ var tests = []Test{
 		"c": "ddd",
 	}}}}, "hello ddd", nil},
 	{`hello {{a[x]}}`, map[string]any{"a": []string{"a", "b"}, "x": 0}, "hello a", nil},
+
+	// maps whose keys cannot be names
+	{`{{#counts}}{{title}} {{f()}}{{/counts}}`, map[string]any{"counts": map[int]int{1: 2}, "title": "T", "f": func() string { return "F" }}, "T F", nil},
+	{`{{counts[1]}}`, map[string]any{"counts": map[int]int{1: 2}}, "2", nil},
+
+	// lines of several section tags are not standalone
+	{"{{#a}}{{#b}}\nx\n{{/b}}{{/a}}\n", map[string]bool{"a": true, "b": true}, "\nx\n\n", nil},
+	{"{{#a}}\n{{^b}}{{/b}}\n{{/a}}", map[string]bool{"a": true}, "\n", nil},
+
+	// negative indexes, slices, strings and arrays
+	{`{{a[-1]}} {{a[-2]}}`, map[string]any{"a": []string{"a", "b"}}, "b a", nil},
+	{`{{#a[1:3]}}{{.}}{{/a[1:3]}}`, map[string]any{"a": []string{"a", "b", "c", "d"}}, "bc", nil},
+	{`{{#a[-2:]}}{{.}}{{/a[-2:]}}|{{#a[:x]}}{{.}}{{/a[:x]}}`, map[string]any{"a": []string{"a", "b", "c", "d"}, "x": 10}, "cd|abcd", nil},
+	{`{{#a[3:1]}}{{.}}{{/a[3:1]}}{{^a[3:1]}}none{{/a[3:1]}}`, map[string]any{"a": []string{"a", "b", "c", "d"}}, "none", nil},
+	{`{{s[0]}}{{s[-1]}} {{s[1:4]}}`, map[string]any{"s": "héllo"}, "ho éll", nil},
+	{`{{a[1].Name}} {{a[-1].Name}} {{a.length}}`, map[string]any{"a": [2]User{{"Mike", 1}, {"Jane", 2}}}, "Jane Jane 2", nil},
+	{`{{#a[:1]}}{{Name}}{{/a[:1]}}`, map[string]any{"a": [2]User{{"Mike", 1}, {"Jane", 2}}}, "Mike", nil},
 }
 
 func TestBasic(t *testing.T) {
@@ -256,10 +283,17 @@ var missing = []Test{
 	{`{{dne}}`, map[string]string{"name": "world"}, "", nil},
 	{`{{dne}}`, User{"Mike", 1}, "", nil},
 	{`{{dne}}`, &User{"Mike", 1}, "", nil},
+	{`{{Clear}}{{Name}}`, &User{"Mike", 1}, "Mike", nil},
 	//dotted names(dot notation)
 	{`"{{a.b.c}}" == ""`, map[string]interface{}{}, `"" == ""`, nil},
 	{`"{{a.b.c.name}}" == ""`, map[string]interface{}{"a": map[string]interface{}{"b": map[string]string{}}, "c": map[string]string{"name": "Jim"}}, `"" == ""`, nil},
 	{`{{#a}}{{b.c}}{{/a}}`, map[string]interface{}{"a": map[string]interface{}{"b": map[string]string{}}, "b": map[string]string{"c": "ERROR"}}, "", nil},
+	{`{{counts.x}}`, map[string]any{"counts": map[int]int{1: 2}}, "", nil},
+	//indexes out of range
+	{`hello {{a[2]}}`, map[string]any{"a": []string{"a", "b"}}, "hello ", nil},
+	{`hello {{a[-3]}}`, map[string]any{"a": []string{"a", "b"}}, "hello ", nil},
+	{`hello {{s[5]}}`, map[string]any{"s": "héllo"}, "hello ", nil},
+	{`hello {{m["b"]}}`, map[string]any{"m": map[string]string{"a": "a"}}, "hello ", nil},
 }
 
 func TestMissing(t *testing.T) {
@@ -286,6 +320,79 @@ func TestMissing(t *testing.T) {
 	}
 }
 
+func TestMissingVariablesOption(t *testing.T) {
+	for _, test := range missing {
+		lenient, err := ParseString(test.tmpl, WithMissingVariables(MissingEmpty))
+		if err != nil {
+			t.Fatal(err)
+		}
+		strict, err := ParseString(test.tmpl, WithMissingVariables(MissingError))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the options take precedence over the global, both ways
+		for _, allow := range []bool{true, false} {
+			AllowMissingVariables = allow
+			output, err := lenient.Render(test.context)
+			if err != nil {
+				t.Errorf("%q expected %q but got error %q", test.tmpl, test.expected, err.Error())
+			} else if output != test.expected {
+				t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+			}
+			output, err = strict.Render(test.context)
+			if err == nil {
+				t.Errorf("%q expected missing variable error but got %q", test.tmpl, output)
+			} else if !strings.Contains(err.Error(), "missing variable") {
+				t.Errorf("%q expected missing variable error but got %q", test.tmpl, err.Error())
+			}
+		}
+		AllowMissingVariables = true
+	}
+}
+
+func TestOptions(t *testing.T) {
+	partials := &StaticProvider{map[string]string{"p": "{{a}}"}}
+	ctx := map[string]string{"a": "<b>"}
+	upper := func(s string) string { return strings.ToUpper(s) }
+	quote := func(v interface{}) (string, error) { return strconv.Quote(fmt.Sprint(v)), nil }
+	cases := []struct {
+		tmpl     string
+		opts     []Option
+		expected string
+	}{
+		{`{{a}}`, nil, "&lt;b&gt;"},
+		{`{{a}}`, []Option{WithRaw()}, "<b>"},
+		{`{{a}}`, []Option{WithEscape(upper)}, "<B>"},
+		{`{{{a}}}`, []Option{WithEscape(upper)}, "<b>"},
+		{`{{a}}`, []Option{WithFormatter(quote)}, `"<b>"`},
+		{`{{>p}}`, []Option{WithPartials(partials)}, "&lt;b&gt;"},
+		{`{{>p}}`, []Option{WithPartials(partials), WithEscape(upper)}, "<B>"},
+		{"-\n  {{>p}}\n", []Option{WithPartials(partials), WithRaw()}, "-\n  <b>"},
+		{`{{b}}`, []Option{WithNil(NilPlaceholder, "-")}, ""},
+	}
+	for _, test := range cases {
+		tmpl, err := ParseString(test.tmpl, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := tmpl.Render(ctx)
+		if err != nil {
+			t.Errorf("%q expected %q but got error %q", test.tmpl, test.expected, err.Error())
+		} else if output != test.expected {
+			t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+		}
+	}
+
+	tmpl, err := ParseString(`{{>q}}`, WithPartials(partials), WithMissingPartials(MissingError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.Render(ctx); !IsMissingPartialError(err) {
+		t.Errorf("expected a missing partial error, got %v", err)
+	}
+}
+
 func TestFile(t *testing.T) {
 	filename := path.Join(path.Join(os.Getenv("PWD"), "tests"), "test1.mustache")
 	expected := "hello world"
@@ -315,6 +422,40 @@ func TestFRender(t *testing.T) {
 	}
 }
 
+func TestFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"templates/page.mustache":           {Data: []byte("{{>header}} {{name}}{{>footer}}")},
+		"templates/header.mustache":         {Data: []byte("<h1>{{title}}</h1>")},
+		"templates/footer":                  {Mode: fs.ModeDir},
+		"templates/footer.stache":           {Data: []byte("!")},
+		"templates/shared/copyright.stache": {Data: []byte("(c)")},
+	}
+	tmpl, err := ParseFS(fsys, "templates/page.mustache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := tmpl.Render(map[string]string{"title": "Hi", "name": "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<h1>Hi</h1> world!"; output != expected {
+		t.Errorf("expected %q got %q", expected, output)
+	}
+
+	partials := &FSProvider{FS: fsys, Paths: []string{"templates", "templates/shared"}}
+	output, err = RenderPartials("{{>header}} {{>copyright}}{{>missing}}", partials, map[string]string{"title": "Hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<h1>Hi</h1> (c)"; output != expected {
+		t.Errorf("expected %q got %q", expected, output)
+	}
+
+	if _, err := ParseFS(fsys, "templates/missing.mustache"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
 func TestPartial(t *testing.T) {
 	filename := path.Join(path.Join(os.Getenv("PWD"), "tests"), "test2.mustache")
 	expected := "hello world"
@@ -341,6 +482,204 @@ func TestPartial(t *testing.T) {
 	compareTags(t, tmpl.Tags(), expectedTags)
 }
 
+func TestDynamicPartial(t *testing.T) {
+	partials := &StaticProvider{map[string]string{
+		"header": "<h1>{{title}}</h1>",
+		"footer": "<footer>{{title}}</footer>",
+	}}
+	tests := []Test{
+		{`{{>*part}}`, map[string]string{"part": "header", "title": "Hi"}, "<h1>Hi</h1>", nil},
+		{`{{>* page.part }}`, map[string]interface{}{"page": map[string]string{"part": "footer"}, "title": "Hi"}, "<footer>Hi</footer>", nil},
+		{`{{#parts}}{{>*.}}{{/parts}}`, map[string]interface{}{"parts": []string{"header", "footer"}, "title": "Hi"}, "<h1>Hi</h1><footer>Hi</footer>", nil},
+		{`[{{>*part}}]`, map[string]string{"title": "Hi"}, "[]", nil},
+		{`[{{>**part}}]`, map[string]string{"part": "header"}, "[]", nil},
+	}
+	for _, test := range tests {
+		output, err := RenderPartials(test.tmpl, partials, test.context)
+		if err != nil {
+			t.Errorf("%q expected %q but got error %q", test.tmpl, test.expected, err.Error())
+		} else if output != test.expected {
+			t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+		}
+	}
+}
+
+type countingProvider struct {
+	StaticProvider
+	gets int
+}
+
+func (cp *countingProvider) Get(name string) (string, error) {
+	cp.gets++
+	return cp.StaticProvider.Get(name)
+}
+
+// chainProvider looks partials up in each of its providers in turn. It cannot
+// be used as a map key.
+type chainProvider []PartialProvider
+
+func (cp chainProvider) Get(name string) (string, error) {
+	for _, p := range cp {
+		if data, err := p.Get(name); !errors.Is(err, ErrPartialNotFound) {
+			return data, err
+		}
+	}
+	return "", ErrPartialNotFound
+}
+
+func TestPartialCache(t *testing.T) {
+	partials := &countingProvider{StaticProvider: StaticProvider{map[string]string{"row": "({{.}})"}}}
+	tmpl, err := ParseStringPartials("{{#rows}}{{>row}}{{/rows}}", partials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make([]int, 500)
+	for i := 0; i < 2; i++ {
+		if _, err := tmpl.Render(map[string]interface{}{"rows": rows}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if partials.gets != 1 {
+		t.Errorf("expected partial to be retrieved once, got %d", partials.gets)
+	}
+
+	partials.Partials["row"] = "[{{.}}]"
+	tmpl.InvalidatePartials("row")
+	output, err := tmpl.Render(map[string]interface{}{"rows": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "[1][2]" || partials.gets != 2 {
+		t.Errorf("expected invalidated partial to be retrieved again, got %q after %d retrievals", output, partials.gets)
+	}
+
+	partials.gets = 0
+	tmpl, err = ParseStringPartials("{{#rows}}{{>row}}{{/rows}}", chainProvider{&StaticProvider{}, partials})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.Render(map[string]interface{}{"rows": rows}); err != nil {
+		t.Fatal(err)
+	}
+	if partials.gets != 1 {
+		t.Errorf("expected partial of a slice provider to be retrieved once, got %d", partials.gets)
+	}
+	if err := tmpl.CheckPartials(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	set, err := ParseSet(map[string]string{
+		"layout":   "<main>{{$body}}{{/body}}</main>",
+		"page":     "{{<layout}}{{$body}}{{>greeting}}{{/body}}{{/layout}}",
+		"greeting": "hello {{name}}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(set.Names(), ","); names != "greeting,layout,page" {
+		t.Errorf("expected names greeting,layout,page got %s", names)
+	}
+	if set.Lookup("missing") != nil {
+		t.Error("expected no template for missing name")
+	}
+
+	var buf bytes.Buffer
+	if err := set.ExecuteTemplate(&buf, "page", map[string]string{"name": "world"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<main>hello world</main>"; buf.String() != expected {
+		t.Errorf("expected %q got %q", expected, buf.String())
+	}
+	if err := set.ExecuteTemplate(&buf, "missing", nil); err == nil {
+		t.Error("expected error for missing template")
+	}
+
+	// partials follow the options of the set, however they are included
+	raw, err := ParseSet(map[string]string{"a": "{{>b}}|\n  {{>b}}\n", "b": "{{x}}"}, WithRaw())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		if err := raw.ExecuteTemplate(&buf, "a", map[string]string{"x": "<i>"}); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "<i>|\n  <i>"; buf.String() != expected {
+			t.Errorf("expected %q got %q", expected, buf.String())
+		}
+		raw.Lookup("a").InvalidatePartials()
+	}
+
+	_, err = ParseSet(map[string]string{
+		"a":  "{{#a}}",
+		"b":  "{{/b}}",
+		"ok": "ok",
+	})
+	var parseError ParseError
+	if err == nil || !errors.As(err, &parseError) {
+		t.Fatalf("expected parse error, got %v", err)
+	}
+	if expected := "a: line 1: Section a has no closing tag\nb: line 1: unmatched close tag"; err.Error() != expected {
+		t.Errorf("expected error %q got %q", expected, err.Error())
+	}
+	sources := map[string]string{"a": "{{>b}}", "b": "{{>nope}}"}
+	if _, err := ParseSet(sources); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseSet(sources, WithMissingPartials(MissingError))
+	var missingErr MissingPartialError
+	if !errors.As(err, &missingErr) || missingErr.Name != "nope" || strings.Count(err.Error(), "nope") != 1 {
+		t.Fatalf("expected missing partial error for nope, got %v", err)
+	}
+}
+
+func TestMissingPartial(t *testing.T) {
+	partials := &StaticProvider{map[string]string{"header": "{{>footer}}\n{{>heder}}"}}
+	tmpl, err := ParseStringPartials("hello\n{{>header}}{{>*dynamic}}", partials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := tmpl.Render(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "hello\n" {
+		t.Errorf("expected missing partials to be empty, got %q", output)
+	}
+
+	tmpl, err = ParseStringPartials("hello\n{{>header}}{{>*dynamic}}", partials, WithMissingPartials(MissingError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.Render(nil)
+	var missingErr MissingPartialError
+	if !errors.As(err, &missingErr) || !errors.Is(err, ErrPartialNotFound) {
+		t.Fatalf("expected missing partial error, got %v", err)
+	}
+	if missingErr.Name != "footer" || missingErr.Template != "header" || missingErr.Line != 1 {
+		t.Errorf("unexpected missing partial error %+v", missingErr)
+	}
+	if expected := `header: line 1: missing partial "footer"`; err.Error() != expected {
+		t.Errorf("expected error %q got %q", expected, err.Error())
+	}
+
+	err = tmpl.CheckPartials()
+	if expected := "header: line 1: missing partial \"footer\"\nheader: line 2: missing partial \"heder\""; err == nil || err.Error() != expected {
+		t.Errorf("expected error %q got %v", expected, err)
+	}
+
+	filename := path.Join(path.Join(os.Getenv("PWD"), "tests"), "test2.mustache")
+	tmpl, err = ParseFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.CheckPartials(); err != nil {
+		t.Error(err)
+	}
+}
+
 /*
 	func TestSectionPartial(t *testing.T) {
 	    filename := path.Join(path.Join(os.Getenv("PWD"), "tests"), "test3.mustache")
@@ -384,56 +723,649 @@ func TestLambda(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	expect := "Hello world. subv1 subv2 nothing!"
-	if output != expect {
-		t.Fatalf("TestLambda expected %q got %q", expect, output)
+	expect := "Hello world. subv1 subv2 nothing!"
+	if output != expect {
+		t.Fatalf("TestLambda expected %q got %q", expect, output)
+	}
+}
+
+func TestLambdaStruct(t *testing.T) {
+	tmpl := `{{#Lambda}}Hello {{Name}}. {{#Sub}}{{.}} {{/Sub}}{{^Negsub}}nothing{{/Negsub}}{{/Lambda}}`
+	data := struct {
+		Name   string
+		Sub    []string
+		Lambda LambdaFunc
+	}{
+		Name: "world",
+		Sub:  []string{"subv1", "subv2"},
+		Lambda: func(text string, render RenderFunc) (string, error) {
+			res, err := render(text)
+			return res + "!", err
+		},
+	}
+
+	output, err := Render(tmpl, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "Hello world. subv1 subv2 nothing!"
+	if output != expect {
+		t.Fatalf("TestLambdaStruct expected %q got %q", expect, output)
+	}
+}
+
+func TestLambdaRawTag(t *testing.T) {
+	tmpl := `{{#Lambda}}Hello {{{Name}}}.{{/Lambda}}`
+	data := struct {
+		Name   string
+		Lambda LambdaFunc
+	}{
+		Name: "<br>",
+		Lambda: func(text string, render RenderFunc) (string, error) {
+			return render(text)
+		},
+	}
+
+	output, err := Render(tmpl, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "Hello <br>."
+	if output != expect {
+		t.Fatalf("TestLambdaStruct expected %q got %q", expect, output)
+	}
+}
+
+func TestInterpolationLambda(t *testing.T) {
+	calls := 0
+	data := map[string]interface{}{
+		"planet": "world",
+		"lambda": func() string {
+			return "{{planet}} <{{calls}}>"
+		},
+		"calls": func() string {
+			calls++
+			return strconv.Itoa(calls)
+		},
+		"failing": func() (string, error) {
+			return "", fmt.Errorf("test err")
+		},
+	}
+
+	output, err := Render(`{{lambda}} {{{lambda}}} {{&lambda}}`, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "world &lt;1&gt; world <2> world <3>"
+	if output != expect {
+		t.Fatalf("TestInterpolationLambda expected %q got %q", expect, output)
+	}
+
+	_, err = Render(`{{failing}}`, data)
+	expectErr := `line 1, column 1: lambda "failing": test err`
+	if err == nil || err.Error() != expectErr {
+		t.Fatalf("TestInterpolationLambda expected error %q got %v", expectErr, err)
+	}
+}
+
+type ctxKey struct{}
+
+type Greeter struct{}
+
+func (Greeter) Greeting(ctx context.Context) string {
+	return fmt.Sprint(ctx.Value(ctxKey{}))
+}
+
+func (Greeter) Greet(ctx context.Context, name string) string {
+	return fmt.Sprint(ctx.Value(ctxKey{}), " ", name)
+}
+
+func TestRenderContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ctxKey{}, "hello")
+	data := map[string]interface{}{
+		"greeter": Greeter{},
+		"lambda": func(ctx context.Context) string {
+			return fmt.Sprint(ctx.Value(ctxKey{}))
+		},
+		"section": func(ctx context.Context, text string, render RenderFunc) (string, error) {
+			return render(fmt.Sprint(ctx.Value(ctxKey{}), " ", text))
+		},
+	}
+	tmpl, err := ParseString(`{{lambda}},{{#section}}{{greeter.Greeting}}{{/section}},{{greeter.Greet("world")}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.FRenderContext(ctx, &buf, data); err != nil {
+		t.Fatal(err)
+	}
+	expect := "hello,hello hello,hello world"
+	if buf.String() != expect {
+		t.Errorf("expected %q got %q", expect, buf.String())
+	}
+
+	// cancel while iterating
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	count := 0
+	data = map[string]interface{}{
+		"items": make([]int, 100),
+		"tick": func() string {
+			count++
+			if count == 3 {
+				cancel()
+			}
+			return "."
+		},
+	}
+	tmpl, err = ParseString("start\n{{#items}}{{tick}}{{/items}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	err = tmpl.FRenderContext(ctx, &buf, data)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if expect := "line 2, column 11: items[2]: context canceled"; err.Error() != expect {
+		t.Errorf("expected error %q got %q", expect, err.Error())
+	}
+	// the output of the lambda which cancelled is not rendered
+	if buf.String() != "start\n.." {
+		t.Errorf("expected rendering to stop at the third item, got %q", buf.String())
+	}
+
+	// cancelled before the first tag, the error has no position
+	err = tmpl.FRenderContext(ctx, io.Discard, data)
+	if expect := "context canceled"; err == nil || err.Error() != expect {
+		t.Errorf("expected error %q got %v", expect, err)
+	}
+}
+
+func TestRenderError(t *testing.T) {
+	partials := &StaticProvider{map[string]string{
+		"item": "{{name}}: {{price}}\n",
+		"list": "{{#orders}}\n  {{#items}}{{>item}}{{/items}}\n{{/orders}}",
+	}}
+	data := map[string]interface{}{
+		"orders": []map[string]interface{}{
+			{"items": []map[string]interface{}{{"name": "a", "price": 1}}},
+			{"items": []map[string]interface{}{{"name": "b", "price": 2}, {"name": "c"}}},
+		},
+	}
+	tmpl, err := ParseString("Orders:\n{{>list}}", WithPartials(partials), WithMissingVariables(MissingError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.Render(data)
+	var renderErr RenderError
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("expected a RenderError, got %v", err)
+	}
+	expected := RenderError{
+		Err:    newMissingVariableError("price"),
+		Name:   "item",
+		Line:   1,
+		Column: 11,
+		Includes: []Include{
+			{Name: "", Line: 2, Column: 1, Partial: "list"},
+			{Name: "list", Line: 2, Column: 13, Partial: "item"},
+		},
+		Path: "orders[1].items[1]",
+	}
+	if !reflect.DeepEqual(renderErr, expected) {
+		t.Errorf("expected %+v got %+v", expected, renderErr)
+	}
+	if !IsMissingVariableError(err) {
+		t.Errorf("expected the RenderError to wrap a MissingVariableError, got %v", err)
+	}
+	if expected := `item: line 1, column 11: orders[1].items[1]: missing variable "price", included from list: line 2, column 13, included from line 2, column 1`; err.Error() != expected {
+		t.Errorf("expected error %q got %q", expected, err.Error())
+	}
+
+	// overrides are located in the template holding them, not in the parent
+	set, err := ParseSet(map[string]string{
+		"child": "{{<p}}\n{{$b}}\nok\n   {{x}}\n{{/b}}\n{{/p}}",
+		"p":     "<\n  {{$b}}{{/b}}\n>",
+	}, WithMissingVariables(MissingError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.ExecuteTemplate(io.Discard, "child", nil)
+	if expected := `child: line 4, column 4: missing variable "x", included from child: line 1, column 1`; err == nil || err.Error() != expected {
+		t.Errorf("expected error %q got %v", expected, err)
+	}
+
+	// the text of lambdas is located at the lambda's tag
+	lambdas := map[string]interface{}{
+		"a": "a",
+		"l": func(text string, render RenderFunc) (string, error) { return render(text) },
+		"fail": func(text string, render RenderFunc) (string, error) {
+			_, _ = render(text)
+			return "", errors.New("failed")
+		},
+		"f": func() string { return "{{nope}}" },
+	}
+	for tmplText, expected := range map[string]string{
+		"x\n{{#l}}{{nope}}{{/l}}":    `line 2, column 1: lambda "l": missing variable "nope"`,
+		"x\n {{f}}":                  `line 2, column 2: missing variable "nope"`,
+		"x\n{{#fail}}{{a}}{{/fail}}": `line 2, column 1: lambda "fail": failed`,
+	} {
+		tmpl, err := ParseString(tmplText, WithMissingVariables(MissingError))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tmpl.Render(lambdas)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q expected error %q got %v", tmplText, expected, err)
+		}
+	}
+}
+
+type Panicky struct{}
+
+func (Panicky) Boom() string {
+	panic("boom")
+}
+
+func TestPanicError(t *testing.T) {
+	data := map[string]interface{}{
+		"items": []Panicky{{}, {}},
+	}
+	tmpl, err := ParseString("hello {{items[0].Boom}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := tmpl.Render(data)
+	var panicErr PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected a PanicError, got %v", err)
+	}
+	if output != "hello " {
+		t.Errorf("expected %q got %q", "hello ", output)
+	}
+	if panicErr.Value != "boom" {
+		t.Errorf("expected panic value %q got %v", "boom", panicErr.Value)
+	}
+	if !bytes.Contains(panicErr.Stack, []byte("Panicky.Boom")) {
+		t.Errorf("expected the stack to hold the panicking call, got\n%s", panicErr.Stack)
+	}
+
+	tmpl, err = ParseString("{{#items}}\n{{Boom}}\n{{/items}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.Render(data)
+	if !IsPanicError(err) {
+		t.Fatalf("expected a PanicError, got %v", err)
+	}
+	if expected := "line 2, column 1: items[0]: panic: boom"; err.Error() != expected {
+		t.Errorf("expected error %q got %q", expected, err.Error())
+	}
+
+	tmpl, err = ParseString("{{#items}}{{Boom}}{{/items}}", WithPanics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("expected WithPanics to propagate the panic, got %v", r)
+		}
+	}()
+	_, _ = tmpl.Render(data)
+	t.Error("expected WithPanics to propagate the panic")
+}
+
+func TestLimits(t *testing.T) {
+	partials := &StaticProvider{map[string]string{
+		"self":  "{{>self}}",
+		"outer": "{{>inner}}",
+		"inner": "x",
+		"block": "<\n  {{$b}}{{/b}}\n>",
+	}}
+	data := map[string]interface{}{
+		"items": make([]int, 10),
+		"fn":    func() string { return "f" },
+		"wrap":  func(text string, render RenderFunc) (string, error) { return render(text) },
+	}
+	// a million iterations, which must stop at the output limit even though
+	// their output is buffered
+	nested := strings.Repeat("{{#items}}", 6) + "abcdefghij" + strings.Repeat("{{/items}}", 6)
+	cases := []struct {
+		tmpl     string
+		limits   Limits
+		expected string
+		err      error
+	}{
+		{`{{>self}}`, Limits{MaxPartialDepth: 5}, "", ErrPartialDepthLimit},
+		{`{{>outer}}`, Limits{MaxPartialDepth: 2}, "x", nil},
+		{`{{>outer}}`, Limits{MaxPartialDepth: 1}, "", ErrPartialDepthLimit},
+		{`{{#items}}ab{{/items}}`, Limits{MaxOutputBytes: 5}, "ababa", ErrOutputLimit},
+		{`{{#items}}ab{{/items}}`, Limits{MaxOutputBytes: 20}, "abababababababababab", nil},
+		{`{{#items}}a{{/items}}`, Limits{MaxIterations: 3}, "aaa", ErrIterationLimit},
+		{`{{#items}}{{#items}}{{/items}}{{/items}}`, Limits{MaxIterations: 100}, "", ErrIterationLimit},
+		{`{{#items}}{{fn}}{{/items}}`, Limits{MaxFunctionCalls: 2}, "ff", ErrFunctionCallLimit},
+		{`{{fn}}{{fn()}}`, Limits{MaxFunctionCalls: 1}, "f", ErrFunctionCallLimit},
+		{`{{#items}}{{fn}}{{/items}}`, Limits{}, "ffffffffff", nil},
+		{"{{<block}}\n{{$b}}\n" + nested + "\n{{/b}}\n{{/block}}", Limits{MaxOutputBytes: 100}, "<\n", ErrOutputLimit},
+		{"{{#wrap}}" + nested + "{{/wrap}}", Limits{MaxOutputBytes: 100}, "", ErrOutputLimit},
+		{"{{<block}}\n{{$b}}\nab\n{{/b}}\n{{/block}}", Limits{MaxOutputBytes: 100}, "<\n  ab\n>", nil},
+	}
+	for _, test := range cases {
+		tmpl, err := ParseString(test.tmpl, WithPartials(partials), WithLimits(test.limits))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = tmpl.FRender(&buf, data)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%q expected error %v got %v", test.tmpl, test.err, err)
+		} else if err != nil && !IsLimitError(err) {
+			t.Errorf("%q expected a LimitError got %T", test.tmpl, err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("%q expected %q got %q", test.tmpl, test.expected, buf.String())
+		}
+	}
+}
+
+type Label string
+
+func (l Label) String() string {
+	return "#" + string(l)
+}
+
+func TestSandbox(t *testing.T) {
+	sandbox := Sandbox{
+		Methods: map[reflect.Type][]string{
+			reflect.TypeOf(User{}):             {"Func1"},
+			reflect.TypeOf(&CallbackHandler{}): {"Lookup"},
+			reflect.TypeOf(Label("")):          {"String"},
+		},
+		Funcs: []string{"allowed"},
+	}
+	data := map[string]interface{}{
+		"user":     &User{"Mike", 1},
+		"callback": &CallbackHandler{},
+		"allowed":  func() string { return "yes" },
+		"denied":   func() string { return "no" },
+		"lambda":   func(text string, render RenderFunc) (string, error) { return text, nil },
+		"upper":    strings.ToUpper,
+		"tag":      Label("go"),
+		"tags":     map[string]interface{}{"all": []Label{"a", "b"}},
+		"when":     map[string]interface{}{"at": time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	cases := []struct {
+		tmpl     string
+		sandbox  *Sandbox
+		expected string
+		denied   string
+	}{
+		{`{{user.Name}}`, &Sandbox{}, "Mike", ""},
+		{`{{user.Func1}}`, &Sandbox{}, "", "Func1"},
+		{`{{user.Func1}}`, &sandbox, "Mike", ""},
+		{`{{user.Func2}}`, &sandbox, "", "Func2"},
+		{`{{user.Func1()}}`, &Sandbox{}, "", "Func1"},
+		{`{{user.Func1()}}`, &sandbox, "Mike", ""},
+		{`{{user.Func2()}}`, &sandbox, "", "Func2"},
+		{`{{callback.a}}`, &sandbox, "AA", ""},
+		{`{{callback.a}}`, &Sandbox{}, "", "Lookup"},
+		{`{{allowed}}`, &sandbox, "yes", ""},
+		{`{{denied}}`, &sandbox, "", "denied"},
+		{`{{#lambda}}x{{/lambda}}`, &sandbox, "", "lambda"},
+		{`{{upper("a")}}`, &sandbox, "", "upper"},
+		{`{{upper("a")}}`, &Sandbox{Funcs: []string{"upper"}}, "A", ""},
+		{`{{upper("a")}}{{user.Func2}}`, nil, "AMike", ""},
+		{`{{tag}}`, &Sandbox{}, "", "String"},
+		{`{{{tags}}}`, &Sandbox{}, "", "String"},
+		{`{{>*tag}}`, &Sandbox{}, "", "String"},
+		{`{{tag}} {{tags.all}}`, &sandbox, "#go [#a #b]", ""},
+		{`{{join(tags.all, ",")}}`, &Sandbox{}, "", "String"},
+		{`{{join(tags.all, ",")}}`, &sandbox, "#a,#b", ""},
+		{`{{{json(when)}}}`, &Sandbox{}, "", "MarshalJSON"},
+		{`{{{json(user)}}}`, &Sandbox{}, `{"Name":"Mike","ID":1}`, ""},
+	}
+	for _, test := range cases {
+		opts := []Option{WithFuncs(StdFuncs())}
+		if test.sandbox != nil {
+			opts = append(opts, WithSandbox(*test.sandbox))
+		}
+		tmpl, err := ParseString(test.tmpl, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := tmpl.Render(data)
+		if test.denied == "" {
+			if err != nil {
+				t.Errorf("%q expected %q but got error %q", test.tmpl, test.expected, err.Error())
+			} else if output != test.expected {
+				t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+			}
+			continue
+		}
+		var sandboxErr SandboxError
+		if !errors.As(err, &sandboxErr) {
+			t.Errorf("%q expected a sandbox error, got %v", test.tmpl, err)
+		} else if sandboxErr.Name != test.denied {
+			t.Errorf("%q expected %q to be denied, got %q", test.tmpl, test.denied, sandboxErr.Name)
+		}
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	funcs := FuncMap{
+		"upper":  strings.ToUpper,
+		"repeat": strings.Repeat,
+		"join": func(sep string, parts ...string) string {
+			return strings.Join(parts, sep)
+		},
+		"half": func(f float64) float64 { return f / 2 },
+		"fail": func() (string, error) { return "", fmt.Errorf("failed") },
+		"greet": func(ctx context.Context, name string) string {
+			return fmt.Sprint(ctx.Value(ctxKey{}), " ", name)
+		},
+	}
+	partials := &StaticProvider{map[string]string{"p": `{{upper(name)}}`}}
+	data := map[string]interface{}{
+		"name":  "world",
+		"count": uint8(3),
+		"ratio": 1.5,
+		"upper": func(s string) string { return "data " + s },
+	}
+	cases := []struct {
+		tmpl     string
+		expected string
+		err      string
+	}{
+		{`{{repeat(name, 2)}}`, "worldworld", ""},
+		{`{{repeat("ab", count)}}`, "ababab", ""},
+		{`{{join("-")}}{{join("-", "a", name)}}`, "a-world", ""},
+		{`{{half(3)}}`, "1.5", ""},
+		{`{{>p}}`, "data world", ""},
+		{`{{greet(name)}}`, "hello world", ""},
+		{`{{repeat(name)}}`, "", `line 1, column 1: function "repeat": wrong number of arguments: got 1`},
+		{`{{repeat(2, 2)}}`, "", `line 1, column 1: function "repeat": argument 1: expected string, got int64`},
+		{`{{repeat(name, ratio)}}`, "", `line 1, column 1: function "repeat": argument 2: expected int, got float64`},
+		{`{{fail()}}`, "", "line 1, column 1: failed"},
+		{`{{missing()}}`, "", `line 1, column 1: missing function "missing"`},
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "hello")
+	for _, test := range cases {
+		tmpl, err := ParseString(test.tmpl, WithPartials(partials), WithFuncs(funcs), WithSandbox(Sandbox{Funcs: []string{"upper"}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = tmpl.FRenderContext(ctx, &buf, data)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q expected error %q got %v", test.tmpl, test.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("%q expected %q but got error %q", test.tmpl, test.expected, err.Error())
+		} else if buf.String() != test.expected {
+			t.Errorf("%q expected %q got %q", test.tmpl, test.expected, buf.String())
+		}
+	}
+
+	for _, bad := range []FuncMap{{"x": 1}, {"x": func() {}}, {"x": func() (int, int) { return 0, 0 }}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected WithFuncs to panic for %v", bad)
+				}
+			}()
+			WithFuncs(bad)
+		}()
+	}
+}
+
+func TestStdFuncs(t *testing.T) {
+	when := time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+	data := map[string]interface{}{
+		"name":  "  World ",
+		"long":  "abcdefgh",
+		"count": 12,
+		"price": 1234567.891,
+		"big":   uint64(1) << 40,
+		"neg":   -1234,
+		"when":  when,
+		"tags":  []string{"a", "b", "c"},
+		"user":  map[string]interface{}{"id": 1, "name": "Mike"},
+		"empty": "",
+		"box":   struct{ X interface{} }{1},
+		"boxes": struct{ X interface{} }{[]int{1}},
+	}
+	cases := []struct {
+		tmpl     string
+		expected string
+	}{
+		{`{{upper(name)}}|{{lower(name)}}|{{trim(name)}}`, "  WORLD |  world |World"},
+		{`{{replace(long, "cd", "-")}}`, "ab-efgh"},
+		{`{{truncate(long, 3)}}|{{truncate(long, 3, "...")}}|{{truncate(long, 10, "...")}}`, "abc|abc...|abcdefgh"},
+		{`[{{pad("ab", 4)}}][{{padLeft("ab", 4)}}][{{pad(long, 4)}}]`, "[ab  ][  ab][abcdefgh]"},
+		{`{{number(price, 2)}}|{{number(count, 0)}}|{{number(big, 1)}}|{{number(neg, 0)}}`, "1,234,567.89|12|1,099,511,627,776.0|-1,234"},
+		{`{{date(when, "DateOnly")}}|{{date(when, "15:04")}}`, "2024-03-05|14:30"},
+		{`{{default(missing, "none")}}|{{default(empty, "none")}}|{{default(count, "none")}}`, "none|none|12"},
+		{`{{join(tags, ", ")}}`, "a, b, c"},
+		{`{{{json(user)}}}|{{{json(tags)}}}`, `{"id":1,"name":"Mike"}|["a","b","c"]`},
+		{`{{len(tags)}}|{{len(long)}}|{{len(user)}}`, "3|8|2"},
+		{`{{eq(count, 12)}}|{{ne(count, 12)}}|{{lt(count, 12)}}|{{le(count, 12)}}|{{gt(price, count)}}|{{ge(big, 1)}}`, "true|false|false|true|true|true"},
+		{`{{eq(long, "abcdefgh")}}|{{lt("a", "b")}}|{{eq(missing, missing)}}|{{eq(box, box)}}`, "true|true|true|true"},
+		{`{{#gt(count, 10)}}many{{/gt(count, 10)}}{{^gt(count, 100)}} but not that many{{/gt(count, 100)}}`, "many but not that many"},
+	}
+	for _, test := range cases {
+		tmpl, err := ParseString(test.tmpl, WithFuncs(StdFuncs()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := tmpl.Render(data)
+		if err != nil {
+			t.Errorf("%q expected %q but got error %q", test.tmpl, test.expected, err.Error())
+		} else if output != test.expected {
+			t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+		}
+	}
+
+	for _, src := range []string{`{{lt(tags, 1)}}`, `{{len(count)}}`, `{{number(long, 2)}}`, `{{date(long, "RFC3339")}}`, `{{pad("", 100000000000)}}`, `{{padLeft("", -1)}}`, `{{truncate(long, -1)}}`, `{{number(count, 100000)}}`, `{{eq(boxes, boxes)}}`} {
+		tmpl, err := ParseString(src, WithFuncs(StdFuncs()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output, err := tmpl.Render(data); err == nil {
+			t.Errorf("%q expected an error but got %q", src, output)
+		} else if IsPanicError(err) {
+			t.Errorf("%q expected an error but got %v", src, err)
+		}
+	}
+
+	// default takes missing arguments even when missing variables are errors
+	strict, err := ParseString(`{{default(missing, "none")}}`, WithFuncs(StdFuncs()), WithMissingVariables(MissingError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, err := strict.Render(data); err != nil || output != "none" {
+		t.Errorf("expected %q got %q, %v", "none", output, err)
+	}
+	strict, err = ParseString(`{{upper(missing)}}`, WithFuncs(StdFuncs()), WithMissingVariables(MissingError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strict.Render(data); !IsMissingVariableError(err) {
+		t.Errorf("expected a missing variable error, got %v", err)
 	}
 }
 
-func TestLambdaStruct(t *testing.T) {
-	tmpl := `{{#Lambda}}Hello {{Name}}. {{#Sub}}{{.}} {{/Sub}}{{^Negsub}}nothing{{/Negsub}}{{/Lambda}}`
-	data := struct {
-		Name   string
-		Sub    []string
-		Lambda LambdaFunc
+func TestExpressions(t *testing.T) {
+	data := map[string]interface{}{
+		"items":      []string{"a", "b"},
+		"keys":       []int{1, 0},
+		"m":          map[int]string{1: "one"},
+		"n":          map[string]interface{}{"a.b": "dotted"},
+		"a:b":        "colon",
+		"first name": "Mike",
+		"person":     map[string]string{"last name": "Smith"},
+		"user":       &User{"Mike", 1},
+		"wrap":       func(s string) string { return "(" + s + ")" },
+		"cat":        func(a, b string) string { return a + b },
+	}
+	cases := []struct {
+		tmpl     string
+		expected string
 	}{
-		Name: "world",
-		Sub:  []string{"subv1", "subv2"},
-		Lambda: func(text string, render RenderFunc) (string, error) {
-			res, err := render(text)
-			return res + "!", err
-		},
+		{`{{wrap(wrap("x"))}}`, "((x))"},
+		{`{{cat(wrap("a, b"), "c.d")}}`, "(a, b)c.d"},
+		{`{{join(items, ", ")}}`, "a, b"},
+		{`{{items[keys[0]]}}{{items[keys[1]]}}`, "ba"},
+		{`{{m[1]}}`, "one"},
+		{`{{n["a.b"]}}`, "dotted"},
+		{`{{join(items[ keys[1] : keys[0] ], ", ")}}{{join(items[:], ", ")}}`, "aa, b"},
+		{`{{a:b}}`, "colon"},
+		{`{{first name}} {{person.last name}}`, "Mike Smith"},
+		{`{{ user.Name }}{{user.Func1()}}`, "MikeMike"},
+		{`{{#user}}{{.Name}}{{/user}}`, "Mike"},
+		{`{{wrap( "x" )}}`, "(x)"},
+		{`{{1.5}}{{-2}}{{0x10}}{{true}}`, "1.5-216true"},
+		{`{{#items}}{{wrap(.)}}{{/items}}`, "(a)(b)"},
+	}
+	for _, test := range cases {
+		tmpl, err := ParseString(test.tmpl, WithFuncs(FuncMap{"join": strings.Join}))
+		if err != nil {
+			t.Fatalf("%q: %v", test.tmpl, err)
+		}
+		output, err := tmpl.Render(data)
+		if err != nil {
+			t.Errorf("%q expected %q but got error %q", test.tmpl, test.expected, err.Error())
+		} else if output != test.expected {
+			t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+		}
 	}
+}
 
-	output, err := Render(tmpl, data)
+func TestLambdaText(t *testing.T) {
+	tmpl, err := ParseStringPartials("{{=<% %>=}}<%#lambda%>{{! x }}<%> p%><%/lambda%>", &StaticProvider{map[string]string{"p": "<{{name}}>"}})
 	if err != nil {
 		t.Fatal(err)
 	}
-	expect := "Hello world. subv1 subv2 nothing!"
-	if output != expect {
-		t.Fatalf("TestLambdaStruct expected %q got %q", expect, output)
-	}
-}
-
-func TestLambdaRawTag(t *testing.T) {
-	tmpl := `{{#Lambda}}Hello {{{Name}}}.{{/Lambda}}`
-	data := struct {
-		Name   string
-		Lambda LambdaFunc
-	}{
-		Name: "<br>",
-		Lambda: func(text string, render RenderFunc) (string, error) {
-			return render(text)
+	tmpl.Escape(func(text string) string {
+		return strings.ToUpper(text)
+	})
+	var text string
+	output, err := tmpl.Render(map[string]interface{}{
+		"name": "world",
+		"lambda": func(t string, render RenderFunc) (string, error) {
+			text = t
+			return render(t + "<%name%>")
 		},
-	}
-
-	output, err := Render(tmpl, data)
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	expect := "Hello <br>."
-	if output != expect {
-		t.Fatalf("TestLambdaStruct expected %q got %q", expect, output)
+	if expect := "{{! x }}<%> p%>"; text != expect {
+		t.Errorf("TestLambdaText expected text %q got %q", expect, text)
+	}
+	if expect := "{{! x }}<WORLD>WORLD"; output != expect {
+		t.Errorf("TestLambdaText expected %q got %q", expect, output)
 	}
 }
 
@@ -449,7 +1381,7 @@ func TestLambdaError(t *testing.T) {
 		t.Fatal("nil error")
 	}
 
-	expect := `lambda "lambda": test err`
+	expect := `line 1, column 1: lambda "lambda": test err`
 	if err.Error() != expect {
 		t.Fatalf("TestLambdaError expected %q got %q", expect, err.Error())
 	}
@@ -467,7 +1399,7 @@ func TestLambdaWrongSignature(t *testing.T) {
 		t.Fatal("nil error")
 	}
 
-	expect := `lambda "lambda" doesn't match required LambaFunc signature`
+	expect := `line 1, column 1: lambda "lambda" doesn't match required LambaFunc signature`
 	if err.Error() != expect {
 		t.Fatalf("TestLambdaWrongSignature expected %q got %q", expect, err.Error())
 	}
@@ -480,6 +1412,7 @@ var malformed = []Test{
 	{`{{`, nil, "", fmt.Errorf("line 1: unmatched open tag")},
 	//invalid syntax - https://github.com/hoisie/mustache/issues/10
 	{`{{#a}}{{#b}}{{/a}}{{/b}}}`, map[string]interface{}{}, "", fmt.Errorf("line 1: interleaved closing tag: a")},
+	{"hello\n{{a b)}}", nil, "", fmt.Errorf("line 2: invalid variable: a b)")},
 }
 
 func TestMalformed(t *testing.T) {
@@ -515,6 +1448,15 @@ var malformedWithParseError = []TestWithParseError{
 	{Test: &Test{`{{`, nil, "", fmt.Errorf("line 1: unmatched open tag")}, errLine: 1, errCode: ErrUnmatchedOpenTag, errReason: ""},
 	// invalid syntax - https://github.com/hoisie/mustache/issues/10
 	{Test: &Test{`{{#a}}{{#b}}{{/a}}{{/b}}}`, map[string]interface{}{}, "", fmt.Errorf("line 1: interleaved closing tag: a")}, errLine: 1, errCode: ErrInterleavedClosingTag, errReason: "a"},
+	// invalid names
+	{Test: &Test{"{{a b)}}", nil, "", nil}, errLine: 1, errCode: ErrInvalidVariable, errReason: "a b)"},
+	{Test: &Test{"\n{{f(a}}", nil, "", nil}, errLine: 2, errCode: ErrInvalidVariable, errReason: "f(a"},
+	{Test: &Test{"\n\n{{{a.}}}", nil, "", nil}, errLine: 3, errCode: ErrInvalidVariable, errReason: "a."},
+	{Test: &Test{"{{&a[0}}", nil, "", nil}, errLine: 1, errCode: ErrInvalidVariable, errReason: "a[0"},
+	{Test: &Test{"{{a[0:1:2]}}", nil, "", nil}, errLine: 1, errCode: ErrInvalidVariable, errReason: "a[0:1:2]"},
+	{Test: &Test{`{{"a}}`, nil, "", nil}, errLine: 1, errCode: ErrInvalidVariable, errReason: `"a`},
+	{Test: &Test{"{{#a}}\n{{#a.1}}{{/a.1}}{{/a}}", nil, "", nil}, errLine: 2, errCode: ErrInvalidVariable, errReason: "a.1"},
+	{Test: &Test{"{{>*a,}}", nil, "", nil}, errLine: 1, errCode: ErrInvalidVariable, errReason: "a,"},
 }
 
 func TestParseError(t *testing.T) {
@@ -535,6 +1477,120 @@ func TestParseError(t *testing.T) {
 	}
 }
 
+func TestParseErrorPosition(t *testing.T) {
+	tests := []struct {
+		tmpl    string
+		line    int
+		column  int
+		offset  int
+		snippet string
+	}{
+		{"hello\n  {{#items}}\n{{.}}\n", 2, 3, 8, "line 2: Section items has no closing tag\n  {{#items}}\n  ^"},
+		{"{{#a}}\n\t{{/b}}", 2, 2, 8, "line 2: interleaved closing tag: b\n\t{{/b}}\n\t^"},
+		{"héllo {{a b)}}", 1, 7, 7, "line 1: invalid variable: a b)\nhéllo {{a b)}}\n      ^"},
+		{"a\r\nb {{=<% %>=}}\r\n<%/c%>", 3, 1, 18, "line 3: unmatched close tag\n<%/c%>\n^"},
+		{"{{a}}{{", 1, 6, 5, "line 1: unmatched open tag\n{{a}}{{\n     ^"},
+	}
+	for _, test := range tests {
+		_, err := ParseString(test.tmpl)
+		var parseError ParseError
+		if !errors.As(err, &parseError) {
+			t.Errorf("%q expected a ParseError, got %v", test.tmpl, err)
+			continue
+		}
+		if parseError.Line != test.line || parseError.Column != test.column || parseError.Offset != test.offset {
+			t.Errorf("%q expected line %d column %d offset %d, got line %d column %d offset %d", test.tmpl, test.line, test.column, test.offset, parseError.Line, parseError.Column, parseError.Offset)
+		}
+		if snippet := parseError.ErrorWithSnippet(); snippet != test.snippet {
+			t.Errorf("%q expected snippet %q got %q", test.tmpl, test.snippet, snippet)
+		}
+	}
+
+	// errors are prefixed with the name of the template, if it has one
+	partials := &StaticProvider{map[string]string{"header": "{{#title}}"}}
+	tmpl, err := ParseStringPartials("{{>header}}", partials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.Render()
+	var parseError ParseError
+	if !errors.As(err, &parseError) || parseError.Name != "header" {
+		t.Fatalf("expected a ParseError in header, got %v", err)
+	}
+	if expected := "header: line 1: Section title has no closing tag"; err.Error() != expected {
+		t.Errorf("expected error %q got %q", expected, err.Error())
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		tmpl   string
+		errors []string
+	}{
+		{"{{#a}}\n{{}}\n{{=<% %>}}\n{{b(c}}\n{{/a}}{{/b}}", []string{
+			"line 2: empty tag",
+			"line 3: Invalid meta tag",
+			"line 4: invalid variable: b(c",
+			"line 5: unmatched close tag",
+		}},
+		// the interleaved closing tag closes b as well as a
+		{"{{#a}}{{#b}}{{/a}}\n{{#c}}", []string{
+			"line 1: interleaved closing tag: a",
+			"line 2: Section c has no closing tag",
+		}},
+		{"{{#a}}{{/x}}{{/a}}{{<p}}{{$b}}{{/p}}", []string{
+			"line 1: interleaved closing tag: x",
+			"line 1: interleaved closing tag: p",
+		}},
+		{"{{#a}}{{^b}}{{c", []string{
+			"line 1: Section a has no closing tag",
+			"line 1: Section b has no closing tag",
+			"line 1: unmatched open tag",
+		}},
+		// errors come in the order of the template, not the order found
+		{"{{#a}}\n{{#b}}\n{{/c}}\n{{", []string{
+			"line 1: Section a has no closing tag",
+			"line 2: Section b has no closing tag",
+			"line 3: interleaved closing tag: c",
+			"line 4: unmatched open tag",
+		}},
+	}
+	for _, test := range tests {
+		tmpl, err := ParseString(test.tmpl, WithErrorRecovery())
+		if tmpl != nil {
+			t.Errorf("%q expected no template", test.tmpl)
+		}
+		var parseErrors ParseErrors
+		if !errors.As(err, &parseErrors) {
+			t.Errorf("%q expected ParseErrors, got %v", test.tmpl, err)
+			continue
+		}
+		if expected := strings.Join(test.errors, "\n"); err.Error() != expected {
+			t.Errorf("%q expected errors %q got %q", test.tmpl, expected, err.Error())
+		}
+	}
+
+	_, err := ParseString("{{#a}}{{#b}}{{/a}}\n{{#c}}", WithErrorRecovery())
+	if !errors.Is(err, ErrInterleavedClosingTag) || !errors.Is(err, ErrSectionNoClosingTag) || errors.Is(err, ErrEmptyTag) {
+		t.Errorf("expected errors.Is to match the codes of the errors, got %v", err)
+	}
+	var parseError ParseError
+	if !errors.As(err, &parseError) || parseError.Code != ErrInterleavedClosingTag {
+		t.Errorf("expected errors.As to find the first ParseError, got %v", parseError)
+	}
+
+	// without recovery, parsing stops at the first error
+	_, err = ParseString("{{#a}}{{#b}}{{/a}}\n{{#c}}")
+	if !errors.As(err, &parseError) || !errors.Is(err, ErrInterleavedClosingTag) || errors.Is(err, ErrSectionNoClosingTag) {
+		t.Errorf("expected a single ParseError, got %v", err)
+	}
+
+	tmpl, err := ParseString("{{#a}}{{b}}{{/a}}", WithErrorRecovery())
+	if err != nil || tmpl == nil {
+		t.Errorf("expected a template, got %v", err)
+	}
+}
+
 type LayoutTest struct {
 	layout   string
 	tmpl     string
@@ -583,6 +1639,63 @@ func TestLayoutToWriter(t *testing.T) {
 	}
 }
 
+type inheritanceTest struct {
+	tmpl     string
+	partials map[string]string
+	context  interface{}
+	expected string
+}
+
+var inheritanceTests = []inheritanceTest{
+	{`{{$title}}Default title{{/title}}`, nil, nil, "Default title"},
+	{`{{$foo}}{{bar}}{{/foo}}`, nil, map[string]string{"bar": "baz"}, "baz"},
+	{`{{<include}}{{/include}}`, map[string]string{"include": "{{$foo}}default content{{/foo}}"}, nil, "default content"},
+	{`{{<super}}{{$title}}sub template title{{/title}}{{/super}}`, map[string]string{"super": "...{{$title}}Default title{{/title}}..."}, nil, "...sub template title..."},
+	{`{{<include}}{{$var}}var in template{{/var}}{{/include}}`, map[string]string{"include": "{{$var}}var in include{{/var}}"}, map[string]string{"var": "var in data"}, "var in template"},
+	{`{{<parent}} ignored {{$foo}}hmm{{/foo}} ignored {{/parent}}`, map[string]string{"parent": "{{$foo}}default content{{/foo}}"}, nil, "hmm"},
+	{
+		`test {{<parent}}{{$stuff}}override1{{/stuff}}{{/parent}} {{<parent}}{{$stuff}}override2{{/stuff}}{{/parent}}`,
+		map[string]string{"parent": "|{{$stuff}}...{{/stuff}}{{$default}} default{{/default}}|"},
+		nil,
+		"test |override1 default| |override2 default|",
+	},
+	{
+		`{{<parent}}{{/parent}}`,
+		map[string]string{
+			"parent":      "{{<older}}{{$a}}p{{/a}}{{/older}}",
+			"older":       "{{<grandParent}}{{$a}}o{{/a}}{{/grandParent}}",
+			"grandParent": "{{$a}}g{{/a}}",
+		},
+		nil,
+		"p",
+	},
+	{
+		`{{<parent}}{{$foo}}override{{/foo}}{{/parent}}`,
+		map[string]string{
+			"parent":  "{{$foo}}default content{{/foo}} {{$bar}}{{<parent2}}{{/parent2}}{{/bar}}",
+			"parent2": "{{$foo}}parent2 default content{{/foo}} {{<parent}}{{$bar}}don't recurse{{/bar}}{{/parent}}",
+		},
+		nil,
+		"override override override don't recurse",
+	},
+	// standalone tags and indentation
+	{"Hi,\n  {{<parent}}{{/parent}}\n", map[string]string{"parent": "one\ntwo\n"}, nil, "Hi,\n  one\n  two\n"},
+	{"{{<parent}}{{$ballmer}}\npeaked\n\n:(\n{{/ballmer}}{{/parent}}", map[string]string{"parent": "{{$ballmer}}peaking{{/ballmer}}"}, nil, "peaked\n\n:(\n"},
+	{"{{<parent}}{{$block}}\n    one\n    two\n{{/block}}{{/parent}}\n", map[string]string{"parent": "Hi,\n  {{$block}}\n  {{/block}}\n"}, nil, "Hi,\n  one\n  two\n"},
+	{"{{<parent}}{{$block}}\none\ntwo\n{{/block}}{{/parent}}\n", map[string]string{"parent": "Hi,\n{{$block}}\n    default\n{{/block}}\n"}, nil, "Hi,\n    one\n    two\n"},
+}
+
+func TestInheritance(t *testing.T) {
+	for _, test := range inheritanceTests {
+		output, err := RenderPartials(test.tmpl, &StaticProvider{test.partials}, test.context)
+		if err != nil {
+			t.Errorf("%q expected %q but got error %q", test.tmpl, test.expected, err.Error())
+		} else if output != test.expected {
+			t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+		}
+	}
+}
+
 type Person struct {
 	FirstName string
 	LastName  string
@@ -684,6 +1797,27 @@ var tagTests = []tagsTest{
 			},
 		},
 	},
+	{
+		tmpl: `{{<parent}}{{$title}}hello {{name}}{{/title}}{{/parent}}`,
+		tags: []tag{
+			{
+				Type: Parent,
+				Name: "parent",
+				Tags: []tag{
+					{
+						Type: Block,
+						Name: "title",
+						Tags: []tag{
+							{
+								Type: Variable,
+								Name: "name",
+							},
+						},
+					},
+				},
+			},
+		},
+	},
 }
 
 func TestTags(t *testing.T) {
@@ -724,7 +1858,7 @@ func compareTags(t *testing.T, actual []Tag, expected []tag) {
 			}
 		case Section, InvertedSection:
 			compareTags(t, tag.Tags(), expected[i].Tags)
-		case Partial:
+		case Partial, Parent, Block:
 			compareTags(t, tag.Tags(), expected[i].Tags)
 		case Invalid:
 			t.Errorf("invalid tag type: %s", tag.Type())
@@ -756,6 +1890,41 @@ func TestCustomEscape(t *testing.T) {
 	}
 }
 
+func TestNilInterpolation(t *testing.T) {
+	data := map[string]interface{}{"a": nil, "b": (*User)(nil), "c": "c"}
+	tests := []struct {
+		policy      NilPolicy
+		placeholder string
+		tmpl        string
+		expected    string
+	}{
+		{NilEmpty, "", "[{{a}}|{{{b}}}|{{&a}}|{{c}}]", "[|||c]"},
+		{NilPlaceholder, "null", "[{{a}}|{{{b}}}|{{&a}}|{{c}}]", "[null|null|null|c]"},
+		{NilError, "", "[{{c}}]", "[c]"},
+	}
+	for _, test := range tests {
+		tmpl, err := ParseString(test.tmpl, WithNil(test.policy, test.placeholder))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := tmpl.Render(data)
+		if err != nil {
+			t.Errorf("%q expected %q but got error %q", test.tmpl, test.expected, err.Error())
+		} else if output != test.expected {
+			t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+		}
+	}
+
+	tmpl, err := ParseStringPartials("{{>p}}", &StaticProvider{map[string]string{"p": "{{b}}"}}, WithNil(NilError, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.Render(data)
+	if !IsNilValueError(err) {
+		t.Errorf("expected nil value error, got %v", err)
+	}
+}
+
 func TestFormatting(t *testing.T) {
 	formatter := func(v any) (string, error) {
 		return fmt.Sprintf("AA%v", v), nil
@@ -798,6 +1967,28 @@ func TestFormatting(t *testing.T) {
 	if output != "BB1" {
 		t.Errorf("expected BB1 got %s", output)
 	}
+
+	// nil values are formatted unless a nil policy is set
+	data := map[string]any{"a": nil, "b": (*User)(nil)}
+	cases := []struct {
+		opts     []Option
+		expected string
+	}{
+		{[]Option{WithFormatter(toJSON)}, "null null "},
+		{[]Option{WithFormatter(toJSON), WithNil(NilPlaceholder, "-")}, "- - "},
+	}
+	for _, test := range cases {
+		tmpl, err := ParseString("{{a}} {{b}} {{c}}", test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := tmpl.Render(data)
+		if err != nil {
+			t.Errorf("expected %q but got error %q", test.expected, err.Error())
+		} else if output != test.expected {
+			t.Errorf("expected %q got %q", test.expected, output)
+		}
+	}
 }
 
 type CallbackHandler struct {
@@ -892,6 +2083,151 @@ func TestJsonTags(t *testing.T) {
 	}
 }
 
+type Base struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type Audit struct {
+	Kind    string `json:"kind"`
+	Created string `mustache:"created" json:"created_at"`
+}
+
+type Account struct {
+	Base
+	*Audit
+	Name  string `mustache:"title"`
+	email string
+}
+
+func TestStructFields(t *testing.T) {
+	account := Account{
+		Base:  Base{ID: 1, Name: "base", Kind: "user"},
+		Audit: &Audit{Kind: "audit", Created: "today"},
+		Name:  "account",
+		email: "a@b.c",
+	}
+	tests := []struct {
+		tmpl     string
+		expected string
+	}{
+		// fields are promoted from embedded structs, and by tag
+		{"{{ID}} {{id}} {{Base.Name}}", "1 1 base"},
+		// shallower fields hide promoted ones
+		{"{{Name}} {{title}} {{name}}", "account account base"},
+		// mustache tags take precedence over json tags
+		{"{{created}}|{{created_at}}|{{Created}}", "today||today"},
+		// ambiguous fields are hidden
+		{"{{Kind}}|{{kind}}|{{Audit.Kind}}", "||audit"},
+		// unexported fields are hidden
+		{"{{email}}", ""},
+	}
+	for _, test := range tests {
+		output, err := Render(test.tmpl, account)
+		if err != nil {
+			t.Errorf("%q: %v", test.tmpl, err)
+		} else if output != test.expected {
+			t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+		}
+	}
+
+	// fields promoted through a nil pointer are missing
+	account.Audit = nil
+	tmpl, err := ParseString("{{created}}", WithMissingVariables(MissingError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.Render(&account)
+	if !IsMissingVariableError(err) {
+		t.Errorf("expected a missing variable error, got %v", err)
+	}
+}
+
+func TestStructTags(t *testing.T) {
+	type Config struct {
+		Host    string `yaml:"host"`
+		Port    int    `json:"port" yaml:"listen_port"`
+		Secret  string `mustache:"-" json:"secret"`
+		Comment string `mustache:"note" yaml:"comment"`
+		Ignored string `json:"-" yaml:"ignored"`
+	}
+	config := Config{"localhost", 8080, "hunter2", "hi", "x"}
+	tests := []struct {
+		tags     []string
+		tmpl     string
+		expected string
+	}{
+		{nil, "{{host}}:{{port}}|{{listen_port}}|{{note}}|{{comment}}", "localhost:8080||hi|"},
+		{nil, "{{Secret}}{{secret}}|{{Ignored}}|{{ignored}}", "|x|"},
+		{[]string{"yaml", "json"}, "{{host}}:{{listen_port}}|{{port}}|{{comment}}|{{ignored}}", "localhost:8080||hi|x"},
+		{[]string{"yaml", "json"}, "{{Secret}}{{secret}}|{{note}}", "|"},
+		{[]string{}, "{{Host}}|{{host}}|{{port}}", "localhost||"},
+	}
+	for _, test := range tests {
+		var opts []Option
+		if test.tags != nil {
+			opts = append(opts, WithTags(test.tags...))
+		}
+		tmpl, err := ParseString(test.tmpl, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := tmpl.Render(config)
+		if err != nil {
+			t.Errorf("%v %q: %v", test.tags, test.tmpl, err)
+		} else if output != test.expected {
+			t.Errorf("%v %q expected %q got %q", test.tags, test.tmpl, test.expected, output)
+		}
+	}
+}
+
+type Contact struct {
+	FirstName string
+	LastName  string `json:"surname"`
+	URL       string
+	Url       string
+}
+
+func (c Contact) FullName() string {
+	return c.FirstName + " " + c.LastName
+}
+
+func TestNameMatching(t *testing.T) {
+	contact := Contact{FirstName: "Jane", LastName: "Doe", URL: "a", Url: "b"}
+	yaml := map[interface{}]interface{}{"first-name": "Jane", "Last_Name": "Doe", "ab": 1, "a_b": 2}
+	tests := []struct {
+		match    MatchPolicy
+		tmpl     string
+		data     interface{}
+		expected string
+	}{
+		{MatchExact, "{{FirstName}}|{{firstname}}|{{first_name}}", contact, "Jane||"},
+		{MatchCaseInsensitive, "{{firstname}}|{{first_name}}|{{SURNAME}}|{{fullname}}", contact, "Jane||Doe|Jane Doe"},
+		{MatchNormalized, "{{first_name}}|{{last-name}}|{{Sur_Name}}|{{full_name}}", contact, "Jane|Doe|Doe|Jane Doe"},
+		// exact matches win over ambiguous ones
+		{MatchCaseInsensitive, "{{URL}}|{{Url}}|{{url}}", contact, "a|b|"},
+		{MatchExact, "{{first-name}}|{{first_name}}", yaml, "Jane|"},
+		{MatchCaseInsensitive, "{{LAST_NAME}}|{{lastname}}", yaml, "Doe|"},
+		{MatchNormalized, "{{firstName}}|{{lastName}}|{{ab}}|{{a_b}}", yaml, "Jane|Doe|1|2"},
+		// ab and a_b both match
+		{MatchNormalized, "{{AB}}|{{a-b}}", yaml, "|"},
+		{MatchNormalized, "{{#contact}}{{first_name}}{{/contact}}", map[string]Contact{"Contact": contact}, "Jane"},
+	}
+	for _, test := range tests {
+		tmpl, err := ParseString(test.tmpl, WithNameMatching(test.match))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := tmpl.Render(test.data)
+		if err != nil {
+			t.Errorf("%q: %v", test.tmpl, err)
+		} else if output != test.expected {
+			t.Errorf("%d %q expected %q got %q", test.match, test.tmpl, test.expected, output)
+		}
+	}
+}
+
 func TestLiteral(t *testing.T) {
 	type Data struct {
 		A string
@@ -1018,3 +2354,115 @@ func TestGeneralFunctionsMap(t *testing.T) {
 		t.Error("err expected")
 	}
 }
+
+func TestConcurrentRender(t *testing.T) {
+	tmpl, err := ParseString(benchTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := benchOrders()
+	expected, err := tmpl.Render(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func() {
+			output, err := tmpl.Render(order)
+			if err == nil && output != expected {
+				err = fmt.Errorf("expected %q got %q", expected, output)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+type benchOrder struct {
+	ID       int
+	Customer *User
+	Items    []benchItem `json:"items"`
+}
+
+type benchItem struct {
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity"`
+}
+
+func (item benchItem) Total() float64 {
+	return item.Price * float64(item.Quantity)
+}
+
+const benchTemplate = `Order {{ID}} for {{Customer.Name}}
+{{#items}}
+- {{name}}: {{quantity}} x {{price}} = {{Total}}
+{{/items}}`
+
+func benchOrders() benchOrder {
+	order := benchOrder{ID: 42, Customer: &User{"Mike", 1}}
+	for i := 0; i < 20; i++ {
+		order.Items = append(order.Items, benchItem{Name: "item " + strconv.Itoa(i), Price: 1.5, Quantity: i})
+	}
+	return order
+}
+
+func BenchmarkRenderStruct(b *testing.B) {
+	tmpl, err := ParseString(benchTemplate)
+	if err != nil {
+		b.Fatal(err)
+	}
+	order := benchOrders()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tmpl.Render(order); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderMap(b *testing.B) {
+	tmpl, err := ParseString(`{{#items}}{{name}} {{user.name}} {{a.b.c}}{{/items}}`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	items := make([]map[string]interface{}, 20)
+	for i := range items {
+		items[i] = map[string]interface{}{"name": "item " + strconv.Itoa(i)}
+	}
+	data := map[string]interface{}{
+		"items": items,
+		"user":  map[string]string{"name": "Mike"},
+		"a":     map[string]interface{}{"b": map[string]int{"c": 1}},
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tmpl.Render(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderParallel(b *testing.B) {
+	tmpl, err := ParseString(benchTemplate)
+	if err != nil {
+		b.Fatal(err)
+	}
+	order := benchOrders()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := tmpl.Render(order); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
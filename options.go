@@ -0,0 +1,126 @@
+package mustache
+
+import "strings"
+
+// Option configures a Template when it is parsed, see ParseString, ParseFile,
+// ParseFS and ParseSet.
+type Option func(*Template)
+
+// WithMissingVariables sets how variables which cannot be found in the context
+// are rendered. With MissingEmpty they render as an empty string; with
+// MissingError, rendering fails with an error. Templates parsed without this
+// option follow the deprecated AllowMissingVariables global.
+func WithMissingVariables(policy MissingPolicy) Option {
+	return func(tmpl *Template) {
+		tmpl.missingVariables = policy
+		tmpl.missingVariablesSet = true
+	}
+}
+
+// WithMissingPartials sets how partials and parents which cannot be found by
+// the PartialProvider are handled when rendering. By default they render as
+// an empty string; with MissingError, rendering fails with a
+// MissingPartialError. Use CheckPartials to detect missing partials right
+// after parsing.
+func WithMissingPartials(policy MissingPolicy) Option {
+	return func(tmpl *Template) {
+		tmpl.missingPartials = policy
+	}
+}
+
+// WithNil sets how nil values, including nil pointers and interfaces, are
+// rendered by variable tags. The placeholder is written as is, without
+// escaping, and only used with NilPlaceholder. By default nil values render
+// as an empty string.
+func WithNil(policy NilPolicy, placeholder string) Option {
+	return func(tmpl *Template) {
+		tmpl.nilPolicy = policy
+		tmpl.nilPlaceholder = placeholder
+	}
+}
+
+// WithEscape sets the function used to escape the values of variable tags. By
+// default values are HTML escaped.
+func WithEscape(fn EscapeFunc) Option {
+	return func(tmpl *Template) {
+		tmpl.escape = fn
+	}
+}
+
+// WithFormatter sets the function used to format the values of variable tags.
+// Formatted values are written as is, without escaping. The function is also
+// given nil values, unless WithNil sets a policy other than NilEmpty; values
+// which cannot be found are not formatted.
+func WithFormatter(fn FormatterFunc) Option {
+	return func(tmpl *Template) {
+		tmpl.formatter = fn
+	}
+}
+
+// WithPartials sets the provider used to retrieve partials and parents.
+func WithPartials(partials PartialProvider) Option {
+	return func(tmpl *Template) {
+		tmpl.partial = partials
+	}
+}
+
+// defaultTags are the struct tags naming fields when WithTags is not used.
+const defaultTags = "mustache,json,yaml"
+
+// WithTags sets the keys of the struct tags which give fields the names
+// templates can use besides their Go names, in order of precedence. The first
+// of the keys a field has decides its name, so with the default order of
+// "mustache", "json" and "yaml", a field tagged `json:"id" yaml:"key"` is
+// named id. Whatever the keys, fields tagged `mustache:"-"` are hidden from
+// templates, even by their Go names.
+func WithTags(keys ...string) Option {
+	tags := strings.Join(keys, ",")
+	return func(tmpl *Template) {
+		tmpl.tags = tags
+	}
+}
+
+// WithNameMatching sets how the names in tags are matched against struct
+// fields, methods and map keys, when no name matches exactly. By default only
+// exact matches are found.
+func WithNameMatching(policy MatchPolicy) Option {
+	return func(tmpl *Template) {
+		tmpl.match = policy
+	}
+}
+
+// WithErrorRecovery makes parsing continue past errors such as unclosed
+// sections or invalid tags, so that all the errors in the template are
+// reported at once, as ParseErrors. This suits linters; the template is only
+// returned if it has no errors.
+func WithErrorRecovery() Option {
+	return func(tmpl *Template) {
+		tmpl.recovery = true
+	}
+}
+
+// WithPanics makes panics raised while rendering, such as by methods of the
+// data, propagate to the caller of FRender instead of being returned as a
+// PanicError.
+func WithPanics() Option {
+	return func(tmpl *Template) {
+		tmpl.panics = true
+	}
+}
+
+// WithRaw disables escaping, so that every variable tag of the template and
+// of its partials renders like a triple mustache.
+func WithRaw() Option {
+	return func(tmpl *Template) {
+		tmpl.forceRaw = true
+	}
+}
+
+// rawOptions returns the options matching the forceRaw argument of the
+// deprecated Raw functions.
+func rawOptions(forceRaw bool) []Option {
+	if forceRaw {
+		return []Option{WithRaw()}
+	}
+	return nil
+}
@@ -1,17 +1,22 @@
 package mustache
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path"
+	"reflect"
 	"regexp"
+	"sync"
 )
 
 // PartialProvider comprises the behaviors required of a struct to be able to provide partials to the mustache rendering
 // engine.
 type PartialProvider interface {
-	// Get accepts the name of a partial and returns the parsed partial, if it could be found; a valid but empty
-	// template, if it could not be found; or nil and error if an error occurred (other than an inability to find
-	// the partial).
+	// Get accepts the name of a partial and returns the partial's template, if it could be found; an error
+	// wrapping ErrPartialNotFound, if it could not be found; or an error, if any other error occurred. Providers may
+	// also return an empty string and no error for a missing partial, in which case it cannot be told apart from an
+	// empty partial.
 	Get(name string) (string, error)
 }
 
@@ -55,7 +60,7 @@ func (fp *FileProvider) Get(name string) (string, error) {
 	}
 
 	if filename == "" {
-		return "", nil
+		return "", ErrPartialNotFound
 	}
 
 	data, err := os.ReadFile(filename)
@@ -68,6 +73,52 @@ func (fp *FileProvider) Get(name string) (string, error) {
 
 var _ PartialProvider = (*FileProvider)(nil)
 
+// FSProvider implements the PartialProvider interface by providing partials drawn from an fs.FS, such as an embed.FS,
+// a zip.Reader or an fstest.MapFS. Partials are searched like FileProvider does, except that `Paths` are
+// slash-separated paths within `FS`. The default for `Paths` is to search the root of `FS`. The default for
+// `Extensions` is to examine, in order, no extension; then ".mustache"; then ".stache".
+type FSProvider struct {
+	FS         fs.FS
+	Paths      []string
+	Extensions []string
+}
+
+// Get accepts the name of a partial and returns the parsed partial.
+func (fp *FSProvider) Get(name string) (string, error) {
+	var paths []string
+	if fp.Paths != nil {
+		paths = fp.Paths
+	} else {
+		paths = []string{""}
+	}
+
+	var exts []string
+	if fp.Extensions != nil {
+		exts = fp.Extensions
+	} else {
+		exts = []string{"", ".mustache", ".stache"}
+	}
+
+	for _, p := range paths {
+		for _, e := range exts {
+			name := path.Join(p, name+e)
+			info, err := fs.Stat(fp.FS, name)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			data, err := fs.ReadFile(fp.FS, name)
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		}
+	}
+
+	return "", ErrPartialNotFound
+}
+
+var _ PartialProvider = (*FSProvider)(nil)
+
 // StaticProvider implements the PartialProvider interface by providing partials drawn from a map, which maps partial
 // name to template contents.
 type StaticProvider struct {
@@ -82,20 +133,124 @@ func (sp *StaticProvider) Get(name string) (string, error) {
 		}
 	}
 
-	return "", nil
+	return "", ErrPartialNotFound
 }
 
 var _ PartialProvider = (*StaticProvider)(nil)
 
-func getPartials(partials PartialProvider, name, indent string) (*Template, error) {
+// nonEmptyLine matches each non empty line of a partial, to indent it.
+var nonEmptyLine = regexp.MustCompile(`(?m:^(.+)$)`)
+
+// getPartials retrieves and compiles the named partial, indented with indent.
+// forceRaw is the setting of the including template, which the partial
+// follows like the text of lambdas.
+func getPartials(partials PartialProvider, name, indent string, forceRaw bool) (*Template, error) {
 	data, err := partials.Get(name)
 	if err != nil {
 		return nil, err
 	}
 
 	// indent non empty lines
-	r := regexp.MustCompile(`(?m:^(.+)$)`)
-	data = r.ReplaceAllString(data, indent+"$1")
+	if indent != "" {
+		data = nonEmptyLine.ReplaceAllString(data, indent+"$1")
+	}
+
+	return parseTemplate(name, data, append([]Option{WithPartials(partials)}, rawOptions(forceRaw)...))
+}
+
+// partialKey identifies a compiled partial.
+type partialKey struct {
+	// partials is the provider, or its providerIdentity if it cannot be used
+	// as a map key
+	partials interface{}
+	name     string
+	indent   string
+}
 
-	return ParseStringPartials(data, partials)
+// providerIdentity identifies providers which are maps or slices, which
+// cannot be used as map keys themselves.
+type providerIdentity struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// newPartialKey returns the key of the named partial of the provider,
+// indented with indent. It reports false for providers which cannot be told
+// apart, such as structs holding maps passed by value.
+func newPartialKey(partials PartialProvider, name, indent string) (partialKey, bool) {
+	v := reflect.ValueOf(partials)
+	switch {
+	case v.Type().Comparable():
+		return partialKey{partials, name, indent}, true
+	case v.Kind() == reflect.Map:
+		return partialKey{providerIdentity{v.Type(), v.Pointer(), 0}, name, indent}, true
+	case v.Kind() == reflect.Slice:
+		return partialKey{providerIdentity{v.Type(), v.Pointer(), v.Len()}, name, indent}, true
+	}
+	return partialKey{}, false
+}
+
+// partialCache holds compiled partials, so that each partial is retrieved and
+// parsed only once no matter how often it is rendered. It is safe for
+// concurrent use.
+type partialCache struct {
+	mu       sync.RWMutex
+	compiled map[partialKey]*Template
+}
+
+func newPartialCache() *partialCache {
+	return &partialCache{compiled: map[partialKey]*Template{}}
+}
+
+// get returns the named partial of the provider, indented with indent,
+// compiling it on first use with the forceRaw setting of the including
+// template. Maps and slices are told apart by identity, while the partials
+// of other providers which cannot be used as a map key, such as structs
+// holding maps passed by value, are compiled every time.
+func (c *partialCache) get(partials PartialProvider, name, indent string, forceRaw bool) (*Template, error) {
+	if c == nil || partials == nil {
+		return getPartials(partials, name, indent, forceRaw)
+	}
+	key, ok := newPartialKey(partials, name, indent)
+	if !ok {
+		return getPartials(partials, name, indent, forceRaw)
+	}
+
+	c.mu.RLock()
+	tmpl, ok := c.compiled[key]
+	c.mu.RUnlock()
+	if ok && tmpl == nil {
+		return nil, ErrPartialNotFound
+	} else if ok {
+		return tmpl, nil
+	}
+
+	// partials which cannot be found are remembered as nil
+	tmpl, err := getPartials(partials, name, indent, forceRaw)
+	if err != nil && !errors.Is(err, ErrPartialNotFound) {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.compiled[key] = tmpl
+	c.mu.Unlock()
+	return tmpl, err
+}
+
+// invalidate drops the named partials, or all partials when no names are
+// given.
+func (c *partialCache) invalidate(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(names) == 0 {
+		c.compiled = map[partialKey]*Template{}
+		return
+	}
+	for key := range c.compiled {
+		for _, name := range names {
+			if key.name == name {
+				delete(c.compiled, key)
+			}
+		}
+	}
 }
@@ -0,0 +1,252 @@
+package mustache
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Sandbox restricts the methods and functions which templates may call, for
+// templates written by untrusted users. Without a sandbox, templates can call
+// any exported method without arguments, any Lookup method, and any function
+// found in the context. With a sandbox, only the methods and functions it
+// lists may be called, so an empty Sandbox disables calls entirely. Calls
+// which are not allowed fail with a SandboxError. This includes the Format,
+// Error and String methods which fmt calls when variable tags and join format
+// values, and the MarshalJSON and MarshalText methods which json calls, so a
+// value such as a time.Time only renders if its String method is allowed.
+type Sandbox struct {
+	// Methods lists the names of the methods which may be called, by
+	// receiver type. Methods of a type T are also allowed on *T.
+	Methods map[reflect.Type][]string
+	// Funcs lists the names of the function values, such as lambdas, which
+	// may be called. A function's name is the key it is found under in the
+	// context.
+	Funcs []string
+}
+
+// WithSandbox restricts the methods and functions the template may call.
+func WithSandbox(sandbox Sandbox) Option {
+	return func(tmpl *Template) {
+		tmpl.sandbox = &sandbox
+	}
+}
+
+// checkMethod returns a SandboxError if the render's sandbox does not allow
+// calling the named method on values of type typ.
+func (rs *renderState) checkMethod(typ reflect.Type, name string) error {
+	if rs.sandbox == nil {
+		return nil
+	}
+	for t := typ; ; t = t.Elem() {
+		for _, method := range rs.sandbox.Methods[t] {
+			if method == name {
+				return nil
+			}
+		}
+		if t.Kind() != reflect.Ptr {
+			break
+		}
+	}
+	return newSandboxError(typ, name)
+}
+
+// checkFunc returns a SandboxError if the render's sandbox does not allow
+// calling the function value found under name. Only the last segment of a
+// dotted name is considered.
+func (rs *renderState) checkFunc(name string) error {
+	if rs.sandbox == nil {
+		return nil
+	}
+	name = name[strings.LastIndex(name, ".")+1:]
+	for _, fn := range rs.sandbox.Funcs {
+		if fn == name {
+			return nil
+		}
+	}
+	return newSandboxError(nil, name)
+}
+
+var (
+	formatterType = reflect.TypeOf((*fmt.Formatter)(nil)).Elem()
+	stringerType  = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// checkFormat returns a SandboxError if formatting v with fmt, as variable
+// tags do, would call a Format, Error or String method which the render's
+// sandbox does not allow. Like fmt, it looks for these methods in the
+// elements and exported fields of v too.
+func (rs *renderState) checkFormat(v reflect.Value) error {
+	if rs.sandbox == nil {
+		return nil
+	}
+	return rs.checkFormatDepth(v, 0)
+}
+
+func (rs *renderState) checkFormatDepth(v reflect.Value, depth int) error {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		return rs.checkFormatDepth(v.Elem(), depth)
+	}
+
+	// fmt calls the first of these methods the value has, and then prints
+	// nothing else
+	typ := v.Type()
+	switch {
+	case typ.Implements(formatterType):
+		return rs.checkMethod(typ, "Format")
+	case typ.Implements(errorType):
+		return rs.checkMethod(typ, "Error")
+	case typ.Implements(stringerType):
+		return rs.checkMethod(typ, "String")
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		// fmt only prints what pointers point to at the top level
+		if depth == 0 && !v.IsNil() {
+			switch v.Elem().Kind() {
+			case reflect.Array, reflect.Slice, reflect.Struct, reflect.Map:
+				return rs.checkFormatDepth(v.Elem(), depth+1)
+			}
+		}
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := rs.checkFormatDepth(v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for iter := v.MapRange(); iter.Next(); {
+			if err := rs.checkFormatDepth(iter.Key(), depth+1); err != nil {
+				return err
+			}
+			if err := rs.checkFormatDepth(iter.Value(), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if err := rs.checkFormatDepth(v.Field(i), depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// argChecks holds, by function pointer, the checks of the registered
+// functions which call methods of their arguments, such as join and json.
+var argChecks = map[uintptr]func(rs *renderState, args []reflect.Value) error{
+	reflect.ValueOf(join).Pointer():   checkJoinArgs,
+	reflect.ValueOf(toJSON).Pointer(): checkJSONArgs,
+}
+
+// checkArgs returns a SandboxError if calling fn with args would call methods
+// of the arguments which the render's sandbox does not allow.
+func (rs *renderState) checkArgs(fn reflect.Value, args []reflect.Value) error {
+	if rs.sandbox == nil {
+		return nil
+	}
+	if check, ok := argChecks[fn.Pointer()]; ok {
+		return check(rs, args)
+	}
+	return nil
+}
+
+// checkJoinArgs checks the elements of the list given to join, which formats
+// each of them with fmt.
+func checkJoinArgs(rs *renderState, args []reflect.Value) error {
+	list := indirect(args[0])
+	if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+		return nil
+	}
+	for i := 0; i < list.Len(); i++ {
+		if err := rs.checkFormat(list.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// checkJSONArgs checks the value given to json, whose MarshalJSON and
+// MarshalText methods encoding/json calls, as well as those of its elements
+// and fields.
+func checkJSONArgs(rs *renderState, args []reflect.Value) error {
+	return rs.checkMarshal(args[0], map[uintptr]bool{})
+}
+
+func (rs *renderState) checkMarshal(v reflect.Value, seen map[uintptr]bool) error {
+	if !v.IsValid() {
+		return nil
+	}
+	typ := v.Type()
+	if v.Kind() != reflect.Interface {
+		// methods with pointer receivers are called on addressable values
+		for _, t := range []reflect.Type{typ, reflect.PtrTo(typ)} {
+			if t != typ && !v.CanAddr() {
+				break
+			}
+			switch {
+			case t.Implements(jsonMarshalerType):
+				return rs.checkMethod(t, "MarshalJSON")
+			case t.Implements(textMarshalerType):
+				return rs.checkMethod(t, "MarshalText")
+			}
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			return rs.checkMarshal(v.Elem(), seen)
+		}
+	case reflect.Ptr:
+		if v.IsNil() || seen[v.Pointer()] {
+			return nil
+		}
+		seen[v.Pointer()] = true
+		return rs.checkMarshal(v.Elem(), seen)
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := rs.checkMarshal(v.Index(i), seen); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for iter := v.MapRange(); iter.Next(); {
+			// keys which are not strings are encoded with MarshalText
+			if key := iter.Key(); key.Kind() != reflect.String {
+				if err := rs.checkMarshal(key, seen); err != nil {
+					return err
+				}
+			}
+			if err := rs.checkMarshal(iter.Value(), seen); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := typ.Field(i)
+			if !field.IsExported() && !field.Anonymous || field.Tag.Get("json") == "-" {
+				continue
+			}
+			if err := rs.checkMarshal(v.Field(i), seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
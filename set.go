@@ -0,0 +1,117 @@
+package mustache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Set is a collection of named templates which can include each other as
+// partials and parents by name. All the templates are parsed once, when the
+// set is created, and share their compiled partials.
+type Set struct {
+	sources   map[string]string
+	templates map[string]*Template
+	cache     *partialCache
+}
+
+// ParseSet compiles a collection of templates, given as a map from template
+// name to template contents. Errors are reported for all the templates at
+// once, each holding the name of the template it occurred in. With
+// WithMissingPartials(MissingError), partials and parents which are not in
+// the set are reported too, as MissingPartialErrors. The options apply to every template of the set, except that partials are always
+// looked up in the set itself.
+func ParseSet(sources map[string]string, opts ...Option) (*Set, error) {
+	set := &Set{
+		sources:   make(map[string]string, len(sources)),
+		templates: make(map[string]*Template, len(sources)),
+		cache:     newPartialCache(),
+	}
+	for name, data := range sources {
+		set.sources[name] = data
+	}
+
+	opts = append(opts[:len(opts):len(opts)], WithPartials(set))
+	var errs []error
+	for _, name := range set.Names() {
+		tmpl, err := parseTemplate(name, set.sources[name], opts)
+		if err != nil {
+			// ParseErrors already hold the name of the template
+			errs = append(errs, err)
+			continue
+		}
+		tmpl.cache = set.cache
+		set.templates[name] = tmpl
+		// templates included without indentation need no parsing at all
+		set.cache.compiled[partialKey{set, name, ""}] = tmpl
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// with MissingError, partials the set lacks fail now rather than when
+	// rendering. CheckPartials follows the partials a template includes, so
+	// the same missing partial may be found from several templates.
+	seen := map[MissingPartialError]bool{}
+	for _, name := range set.Names() {
+		tmpl := set.templates[name]
+		if tmpl.missingPartials != MissingError {
+			continue
+		}
+		if err := tmpl.CheckPartials(); err != nil {
+			for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+				if missing, ok := err.(MissingPartialError); ok {
+					if seen[missing] {
+						continue
+					}
+					seen[missing] = true
+				}
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return set, nil
+}
+
+// Names returns the names of the templates in the set, in sorted order.
+func (s *Set) Names() []string {
+	names := make([]string, 0, len(s.sources))
+	for name := range s.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the named template, or nil if there is no such template in
+// the set.
+func (s *Set) Lookup(name string) *Template {
+	return s.templates[name]
+}
+
+// ExecuteTemplate uses the given data source - generally a map or struct - to
+// render the named template to an io.Writer.
+func (s *Set) ExecuteTemplate(w io.Writer, name string, context ...interface{}) error {
+	tmpl := s.Lookup(name)
+	if tmpl == nil {
+		return fmt.Errorf("template %q not found", name)
+	}
+	return tmpl.FRender(w, context...)
+}
+
+// Get accepts the name of a template in the set and returns its contents,
+// which lets the templates of the set be used as partials.
+func (s *Set) Get(name string) (string, error) {
+	data, ok := s.sources[name]
+	if !ok {
+		return "", ErrPartialNotFound
+	}
+	return data, nil
+}
+
+var _ PartialProvider = (*Set)(nil)
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"testing"
 )
 
@@ -14,20 +15,6 @@ var disabledTests = map[string]map[string]struct{}{
 		// both are valid escapings, and we validate the behavior in mustache_test.go
 		"HTML Escaping":                      struct{}{},
 		"Implicit Iterators - HTML Escaping": struct{}{},
-		// Not currently compliant with null interpolation tests added in v1.2.1
-		"Basic Null Interpolation":           struct{}{},
-		"Triple Mustache Null Interpolation": struct{}{},
-		"Ampersand Null Interpolation":       struct{}{},
-	},
-	"~inheritance.json": {}, // not implemented
-	"~lambdas.json": {
-		"Interpolation":                        struct{}{},
-		"Interpolation - Expansion":            struct{}{},
-		"Interpolation - Alternate Delimiters": struct{}{},
-		"Interpolation - Multiple Calls":       struct{}{},
-		"Escaping":                             struct{}{},
-		"Section - Alternate Delimiters":       struct{}{},
-		"Inverted Section":                     struct{}{},
 	},
 }
 
@@ -113,7 +100,31 @@ func runTest(t *testing.T, file string, test *specTest) {
 
 // Define the lambda functions to match those in the spec tests. The javascript
 // implementations from the spec tests are included for reference.
-var lambdas = map[string]LambdaFunc{
+var lambdas = map[string]interface{}{
+	"Interpolation": func() string {
+		// function() { return "world" }
+		return "world"
+	},
+	"Interpolation - Expansion": func() string {
+		// function() { return "{{planet}}" }
+		return "{{planet}}"
+	},
+	"Interpolation - Alternate Delimiters": func() string {
+		// function() { return "|planet| => {{planet}}" }
+		return "|planet| => {{planet}}"
+	},
+	"Interpolation - Multiple Calls": func() func() string {
+		// function() { return (globalThis.calls = (globalThis.calls || 0) + 1) }
+		calls := 0
+		return func() string {
+			calls++
+			return strconv.Itoa(calls)
+		}
+	}(),
+	"Escaping": func() string {
+		// function() { return ">" }
+		return ">"
+	},
 	"Section": func(text string, render RenderFunc) (string, error) {
 		// function(txt) { return (txt == "{{x}}" ? "yes" : "no") }
 		if text == "{{x}}" {
@@ -126,8 +137,16 @@ var lambdas = map[string]LambdaFunc{
 		// function(txt) { return txt + "{{planet}}" + txt }
 		return render(text + "{{planet}}" + text)
 	},
+	"Section - Alternate Delimiters": func(text string, render RenderFunc) (string, error) {
+		// function(txt) { return txt + "{{planet}} => |planet|" + txt }
+		return render(text + "{{planet}} => |planet|" + text)
+	},
 	"Section - Multiple Calls": func(text string, render RenderFunc) (string, error) {
 		// function(txt) { return "__" + txt + "__" }
 		return render("__" + text + "__")
 	},
+	"Inverted Section": LambdaFunc(func(text string, render RenderFunc) (string, error) {
+		// function(txt) { return false }
+		return "", nil
+	}),
 }